@@ -1,13 +1,45 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"io/fs"
 	"log"
+	"spacecraftsim/internal/config"
 	"spacecraftsim/internal/server"
 )
 
 func main() {
+	configPath := flag.String("config", "ship.yaml", "Server configuration file")
+	replay := flag.String("replay", "", "Bus recording to replay instead of running the ship live")
+	replayFast := flag.Bool("replay-fast", false, "Replay as fast as possible instead of at the original pace")
+	flag.Parse()
+
+	// Run without a configuration when the default file does not exist
+	explicit := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			explicit = true
+		}
+	})
+	cfg, err := config.Load(*configPath)
+	if err != nil && !explicit && errors.Is(err, fs.ErrNotExist) {
+		log.Printf("No config file %s, using defaults", *configPath)
+		cfg, err = config.Empty(), nil
+	}
+	if err != nil {
+		log.Fatalf("Config error: %v", err)
+	}
+	if *replay != "" {
+		if cfg.Bus == nil {
+			cfg.Bus = &config.BusConfig{}
+		}
+		cfg.Bus.Replay = *replay
+		cfg.Bus.ReplayFast = *replayFast
+	}
+
 	// Create and start the server
-	srv := server.New(":8080")
+	srv := server.New(":8080", cfg)
 	log.Printf("Starting TCP server on :8080...")
 
 	if err := srv.Start(); err != nil {
@@ -3,81 +3,311 @@ package bus
 import (
 	"fmt"
 	"log"
+	"sort"
 	"sync"
+	"sync/atomic"
 
 	"spacecraftsim/internal/device"
 )
 
 // MessageBus implements the device.Bus interface
+// Topics are hierarchical, with levels separated by "/" such as
+// "thermal/node3/temp". Subscriptions may use the MQTT wildcards "+" for
+// one level and "#" for all remaining levels, e.g. "thermal/+/temp" or
+// "thermal/#"; a device matching several of its subscriptions receives a
+// message once.
+// Messages on retained topics are cached by topic and ID; subscribing to a
+// retained topic delivers the cached messages at once.
+// Requests are answered on a reply topic of their own, see Request.
+// Interceptors may inspect, modify, delay, duplicate or drop messages on
+// publish or per subscriber, see Interceptor. The published messages can
+// be recorded and replayed later, and the traffic of every topic is
+// counted, see TopicStats.
+// Messages are delivered synchronously by the publisher unless async
+// delivery is enabled, in which case every subscriber reads its messages
+// from its own bounded queue.
 type MessageBus struct {
-	subscribers map[string]map[device.Device]struct{}
-	mu          sync.RWMutex
+	topics      *topicNode                        // subscription trie
+	subscribers map[device.Device]map[string]bool // topic filters by subscriber
+	queues      map[device.Device]*queue          // by subscriber, nil for synchronous delivery
+	retained    *retainCache
+	pending     *pendingRequests
+	stats       *topicStats
+	// interceptors is replaced, never modified, when the chain changes
+	interceptors    []*Interceptor
+	nextInterceptor int
+	recorder        *recorder   // nil unless recording
+	replaying       atomic.Bool // drop live messages during a replay
+	queueSize       int
+	overflow        OverflowPolicy
+	onDelivery      func(dev device.Device, err error)
+	mu              sync.RWMutex
 }
 
 // NewMessageBus creates a new message bus
 func NewMessageBus() *MessageBus {
 	return &MessageBus{
-		subscribers: make(map[string]map[device.Device]struct{}),
+		topics:      newTopicNode(),
+		subscribers: make(map[device.Device]map[string]bool),
+		retained:    newRetainCache(),
+		pending:     newPendingRequests(),
+		stats:       newTopicStats(),
 	}
 }
 
 // Publish sends a message to all subscribers of a topic
+// Subscribers are called without holding the bus lock, so they may publish
+// and subscribe while handling the message. Messages are dropped during a
+// replay.
 func (b *MessageBus) Publish(topic string, msg device.Message) error {
+	if err := validateTopic(topic); err != nil {
+		return err
+	}
+	if b.replaying.Load() {
+		return nil
+	}
+	return b.publishChain(topic, msg)
+}
+
+// publishChain runs a message through the publish interceptors before
+// publishing it
+func (b *MessageBus) publishChain(topic string, msg device.Message) error {
+	b.mu.RLock()
+	chain := b.interceptors
+	b.mu.RUnlock()
+
+	return intercept(chain, 0, topic, "", msg, func(msg device.Message) error {
+		return b.publish(topic, msg)
+	})
+}
+
+// publish retains a message that passed the interceptors and sends it to
+// the subscribers of its topic
+func (b *MessageBus) publish(topic string, msg device.Message) error {
+	b.retained.store(topic, msg)
+	if b.pending.answer(topic, msg) {
+		b.stats.record(topic, msg, 1)
+		return nil
+	}
+
+	matched := make(map[device.Device]struct{})
 	b.mu.RLock()
-	defer b.mu.RUnlock()
+	b.topics.match(topic, matched)
+	b.mu.RUnlock()
+
+	b.stats.record(topic, msg, len(matched))
+	return b.send(topic, matched, msg)
+}
+
+// Retain keeps the last message of every ID published on topics matching
+// the given filters
+// Commands and replies must not be retained, new subscribers would
+// execute them again
+func (b *MessageBus) Retain(filters ...string) error {
+	for _, filter := range filters {
+		if err := validateFilter(filter); err != nil {
+			return err
+		}
+	}
+	b.retained.retain(filters...)
+	return nil
+}
+
+// Retained returns the retained messages on topics matching a filter,
+// ordered by topic and ID
+func (b *MessageBus) Retained(filter string) ([]Retained, error) {
+	if err := validateFilter(filter); err != nil {
+		return nil, err
+	}
+	return b.retained.match(filter), nil
+}
 
-	subs, exists := b.subscribers[topic]
-	if !exists {
-		return nil // No subscribers is not an error
+// send delivers a message to the given subscribers directly or through
+// their queues, after the interceptors of each subscriber
+// Broadcasts have no topic.
+func (b *MessageBus) send(topic string, subs map[device.Device]struct{}, msg device.Message) error {
+	b.mu.RLock()
+	chain := b.interceptors
+	queues := make(map[device.Device]*queue, len(subs))
+	if b.queues != nil {
+		for dev := range subs {
+			queues[dev] = b.queues[dev]
+		}
 	}
+	b.mu.RUnlock()
 
 	for dev := range subs {
-		if err := dev.HandleInput(msg); err != nil {
-			log.Printf("Error handling message for device %s: %v", dev.ID(), err)
+		dev, q := dev, queues[dev]
+		err := intercept(chain, 0, topic, dev.ID(), msg, func(msg device.Message) error {
+			if q != nil {
+				q.push(msg)
+			} else {
+				b.deliver(dev, msg)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("Error intercepting message for device %s: %v", dev.ID(), err)
 		}
 	}
+	return nil
+}
+
+// deliver passes a message to a subscriber and reports the result
+func (b *MessageBus) deliver(dev device.Device, msg device.Message) {
+	err := dev.HandleInput(msg)
+	if err != nil {
+		log.Printf("Error handling message for device %s: %v", dev.ID(), err)
+	}
+
+	b.mu.RLock()
+	onDelivery := b.onDelivery
+	b.mu.RUnlock()
+	if onDelivery != nil {
+		onDelivery(dev, err)
+	}
+}
 
+// SetDeliveryHandler sets a function notified after each delivery with
+// the error returned by the subscriber
+func (b *MessageBus) SetDeliveryHandler(handler func(dev device.Device, err error)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onDelivery = handler
+}
+
+// EnableAsync switches to asynchronous delivery
+// Every subscriber gets a queue of size messages served by its own
+// goroutine, and overflow decides what a publisher does when the queue is
+// full. Publishers no longer wait for subscribers, except with
+// OverflowBlock where a subscriber publishing to a full queue of its own
+// waits forever.
+func (b *MessageBus) EnableAsync(size int, overflow OverflowPolicy) error {
+	if size <= 0 {
+		return fmt.Errorf("queue size must be positive")
+	}
+	switch overflow {
+	case OverflowBlock, OverflowDropOldest, OverflowDropNewest:
+	default:
+		return fmt.Errorf("unknown overflow policy %s", overflow)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.queues != nil {
+		return fmt.Errorf("async delivery is already enabled")
+	}
+	b.queueSize, b.overflow = size, overflow
+	b.queues = make(map[device.Device]*queue)
+	for dev := range b.subscribers {
+		b.startQueue(dev)
+	}
 	return nil
 }
 
-// Subscribe registers a device to receive messages on a topic
-func (b *MessageBus) Subscribe(topic string, dev device.Device) error {
+// startQueue starts serving the queue of a subscriber if it has none
+// The caller must hold b.mu for writing
+func (b *MessageBus) startQueue(dev device.Device) {
+	if _, exists := b.queues[dev]; exists {
+		return
+	}
+	q := newQueue(b, dev, b.queueSize, b.overflow)
+	b.queues[dev] = q
+	go q.run()
+}
+
+// QueueStats returns the state of the subscriber queues ordered by device,
+// nil for synchronous delivery
+func (b *MessageBus) QueueStats() []QueueStats {
+	b.mu.RLock()
+	queues := make([]*queue, 0, len(b.queues))
+	for _, q := range b.queues {
+		queues = append(queues, q)
+	}
+	b.mu.RUnlock()
+
+	if len(queues) == 0 {
+		return nil
+	}
+	stats := make([]QueueStats, len(queues))
+	for i, q := range queues {
+		stats[i] = q.stats()
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Device < stats[j].Device })
+	return stats
+}
+
+// Close stops the subscriber queues, messages still queued are dropped
+func (b *MessageBus) Close() {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if _, exists := b.subscribers[topic]; !exists {
-		b.subscribers[topic] = make(map[device.Device]struct{})
+	for dev, q := range b.queues {
+		q.close()
+		delete(b.queues, dev)
 	}
+}
+
+// Subscribe registers a device to receive messages on a topic
+// The topic may contain wildcards. Retained messages on matching topics
+// are delivered before Subscribe returns, or queued with async delivery.
+func (b *MessageBus) Subscribe(topic string, dev device.Device) error {
+	if err := validateFilter(topic); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	if _, exists := b.subscribers[dev]; !exists {
+		b.subscribers[dev] = make(map[string]bool)
+	}
+	b.subscribers[dev][topic] = true
+	b.topics.add(topic, dev)
+	if b.queues != nil {
+		b.startQueue(dev)
+	}
+	b.mu.Unlock()
 
-	b.subscribers[topic][dev] = struct{}{}
+	subs := map[device.Device]struct{}{dev: {}}
+	for _, r := range b.retained.match(topic) {
+		if err := b.send(r.Topic, subs, r.Message); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 // Unsubscribe removes a device's subscription to a topic
+// The queue of a device left without subscriptions is stopped
 func (b *MessageBus) Unsubscribe(topic string, dev device.Device) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if subs, exists := b.subscribers[topic]; exists {
-		delete(subs, dev)
-		if len(subs) == 0 {
-			delete(b.subscribers, topic)
-		}
+	if !b.subscribers[dev][topic] {
+		return nil
+	}
+	b.topics.remove(topic, dev)
+	delete(b.subscribers[dev], topic)
+	if len(b.subscribers[dev]) > 0 {
+		return nil
 	}
 
+	delete(b.subscribers, dev)
+	if q, exists := b.queues[dev]; exists {
+		q.close()
+		delete(b.queues, dev)
+	}
 	return nil
 }
 
 // Broadcast sends a message to all devices
 func (b *MessageBus) Broadcast(msg device.Message) error {
 	b.mu.RLock()
-	defer b.mu.RUnlock()
-
-	for topic := range b.subscribers {
-		if err := b.Publish(topic, msg); err != nil {
-			return fmt.Errorf("error broadcasting to topic %s: %w", topic, err)
-		}
+	all := make(map[device.Device]struct{}, len(b.subscribers))
+	for dev := range b.subscribers {
+		all[dev] = struct{}{}
 	}
+	b.mu.RUnlock()
 
-	return nil
+	return b.send("", all, msg)
 }
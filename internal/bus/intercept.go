@@ -0,0 +1,108 @@
+package bus
+
+import (
+	"fmt"
+
+	"spacecraftsim/internal/device"
+)
+
+// InterceptFunc processes a message on its way to the subscribers
+// It passes the message on by calling next, possibly with a modified copy.
+// Not calling next drops the message, calling it several times duplicates
+// it and calling it later, from another goroutine, delays it.
+type InterceptFunc func(topic string, msg device.Message, next func(device.Message) error) error
+
+// Interceptor is a hook on the messages of the bus
+// Interceptors without a device run once when a message is published,
+// before it is retained or matched to subscribers. Interceptors with a
+// device run for every delivery to that subscriber, including retained
+// messages and broadcasts. Interceptors of a stage run in the order they
+// were added.
+type Interceptor struct {
+	ID     int    // assigned by AddInterceptor
+	Name   string // describes the interceptor, e.g. "trace"
+	Topic  string // topic filter, may contain wildcards; empty for all topics
+	Source string // publishing device, empty for all sources
+	Device string // receiving device, empty to intercept on publish
+	Handle InterceptFunc
+}
+
+// matches reports whether the interceptor applies to a message
+func (ic *Interceptor) matches(topic, dev string, msg device.Message) bool {
+	if ic.Device != dev {
+		return false
+	}
+	if ic.Topic != "" && !matchFilter(ic.Topic, topic) {
+		return false
+	}
+	return ic.Source == "" || ic.Source == msg.Source
+}
+
+// AddInterceptor adds an interceptor to the end of the chain and returns
+// its ID
+func (b *MessageBus) AddInterceptor(ic Interceptor) (int, error) {
+	if ic.Handle == nil {
+		return 0, fmt.Errorf("interceptor %s has no handler", ic.Name)
+	}
+	if ic.Topic != "" {
+		if err := validateFilter(ic.Topic); err != nil {
+			return 0, fmt.Errorf("interceptor %s: %w", ic.Name, err)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextInterceptor++
+	ic.ID = b.nextInterceptor
+	// Copy on write, so messages in flight keep the chain they started with
+	chain := make([]*Interceptor, len(b.interceptors), len(b.interceptors)+1)
+	copy(chain, b.interceptors)
+	b.interceptors = append(chain, &ic)
+	return ic.ID, nil
+}
+
+// RemoveInterceptor removes an interceptor from the chain
+func (b *MessageBus) RemoveInterceptor(id int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, ic := range b.interceptors {
+		if ic.ID == id {
+			chain := make([]*Interceptor, 0, len(b.interceptors)-1)
+			chain = append(chain, b.interceptors[:i]...)
+			b.interceptors = append(chain, b.interceptors[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("interceptor %d not found", id)
+}
+
+// Interceptors returns the interceptors in chain order
+func (b *MessageBus) Interceptors() []Interceptor {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	list := make([]Interceptor, len(b.interceptors))
+	for i, ic := range b.interceptors {
+		list[i] = *ic
+	}
+	return list
+}
+
+// intercept runs a message through the interceptors of a chain that apply
+// to it, starting at index i, and then passes it to final
+// dev is empty on publish and the receiving device on delivery
+func intercept(chain []*Interceptor, i int, topic, dev string, msg device.Message, final func(device.Message) error) error {
+	for ; i < len(chain); i++ {
+		ic := chain[i]
+		if !ic.matches(topic, dev, msg) {
+			continue
+		}
+		next := i + 1
+		return ic.Handle(topic, msg, func(m device.Message) error {
+			return intercept(chain, next, topic, dev, m, final)
+		})
+	}
+	return final(msg)
+}
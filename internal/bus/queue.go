@@ -0,0 +1,132 @@
+package bus
+
+import (
+	"sync"
+
+	"spacecraftsim/internal/device"
+)
+
+// OverflowPolicy decides what happens to a message published to a full
+// subscriber queue
+type OverflowPolicy string
+
+const (
+	// OverflowBlock makes the publisher wait for room in the queue
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowDropOldest discards the oldest queued message
+	OverflowDropOldest OverflowPolicy = "drop_oldest"
+	// OverflowDropNewest discards the published message
+	OverflowDropNewest OverflowPolicy = "drop_newest"
+)
+
+// QueueStats describes the queue of a subscriber
+type QueueStats struct {
+	Device    string `json:"device"`
+	Depth     int    `json:"depth"`     // messages waiting
+	MaxDepth  int    `json:"max_depth"` // highest depth reached
+	Capacity  int    `json:"capacity"`
+	Delivered uint64 `json:"delivered"`
+	Dropped   uint64 `json:"dropped"`
+}
+
+// queue holds the messages waiting for a subscriber
+// A goroutine delivers them in publication order.
+type queue struct {
+	bus       *MessageBus
+	dev       device.Device
+	capacity  int
+	overflow  OverflowPolicy
+	mu        sync.Mutex
+	changed   *sync.Cond // signalled when messages are added or removed
+	messages  []device.Message
+	closed    bool
+	maxDepth  int
+	delivered uint64
+	dropped   uint64
+}
+
+// newQueue creates the queue of a subscriber
+func newQueue(b *MessageBus, dev device.Device, capacity int, overflow OverflowPolicy) *queue {
+	q := &queue{bus: b, dev: dev, capacity: capacity, overflow: overflow}
+	q.changed = sync.NewCond(&q.mu)
+	return q
+}
+
+// push adds a message to the queue applying the overflow policy
+func (q *queue) push(msg device.Message) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.messages) >= q.capacity && !q.closed {
+		switch q.overflow {
+		case OverflowDropNewest:
+			q.dropped++
+			return
+		case OverflowDropOldest:
+			q.messages = q.messages[1:]
+			q.dropped++
+		default:
+			q.changed.Wait()
+		}
+	}
+	if q.closed {
+		q.dropped++
+		return
+	}
+
+	q.messages = append(q.messages, msg)
+	if len(q.messages) > q.maxDepth {
+		q.maxDepth = len(q.messages)
+	}
+	q.changed.Broadcast()
+}
+
+// run delivers queued messages until the queue is closed
+func (q *queue) run() {
+	for {
+		q.mu.Lock()
+		for len(q.messages) == 0 && !q.closed {
+			q.changed.Wait()
+		}
+		if q.closed {
+			q.mu.Unlock()
+			return
+		}
+		msg := q.messages[0]
+		q.messages = q.messages[1:]
+		q.changed.Broadcast()
+		q.mu.Unlock()
+
+		q.bus.deliver(q.dev, msg)
+
+		q.mu.Lock()
+		q.delivered++
+		q.mu.Unlock()
+	}
+}
+
+// close stops the queue and releases waiting publishers
+func (q *queue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.dropped += uint64(len(q.messages))
+	q.messages = nil
+	q.changed.Broadcast()
+}
+
+// stats returns the state of the queue
+func (q *queue) stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return QueueStats{
+		Device:    q.dev.ID(),
+		Depth:     len(q.messages),
+		MaxDepth:  q.maxDepth,
+		Capacity:  q.capacity,
+		Delivered: q.delivered,
+		Dropped:   q.dropped,
+	}
+}
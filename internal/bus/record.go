@@ -0,0 +1,172 @@
+package bus
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"spacecraftsim/internal/device"
+)
+
+// Record is a message published on the bus at a simulation time
+type Record struct {
+	Time    time.Duration // simulation time of publication since the recording started
+	Topic   string
+	Message device.Message
+}
+
+// recordLine is a record as written to a recording, one JSON object per
+// line with the empty message fields left out
+type recordLine struct {
+	Time          time.Duration `json:"t"`
+	Topic         string        `json:"topic"`
+	ID            string        `json:"id"`
+	Values        []interface{} `json:"v,omitempty"`
+	Stamp         int64         `json:"ts,omitempty"` // message time in Unix nanoseconds
+	Source        string        `json:"src,omitempty"`
+	Destination   string        `json:"dst,omitempty"`
+	CorrelationID string        `json:"cid,omitempty"`
+	ReplyTo       string        `json:"rt,omitempty"`
+	Error         string        `json:"err,omitempty"`
+}
+
+// recorder writes the published messages to a recording
+type recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	now func() time.Duration
+	id  int // interceptor ID
+}
+
+// StartRecording appends every message published from now on to w, with
+// the simulation time returned by now
+// Messages are recorded as they reach the subscribers, after the
+// interceptors added before the recording.
+func (b *MessageBus) StartRecording(w io.Writer, now func() time.Duration) error {
+	b.mu.RLock()
+	recording := b.recorder != nil
+	b.mu.RUnlock()
+	if recording {
+		return fmt.Errorf("bus is already recording")
+	}
+
+	r := &recorder{enc: json.NewEncoder(w), now: now}
+	id, err := b.AddInterceptor(Interceptor{Name: "record", Handle: r.intercept})
+	if err != nil {
+		return err
+	}
+	r.id = id
+
+	b.mu.Lock()
+	b.recorder = r
+	b.mu.Unlock()
+	return nil
+}
+
+// StopRecording stops writing published messages
+func (b *MessageBus) StopRecording() error {
+	b.mu.Lock()
+	r := b.recorder
+	b.recorder = nil
+	b.mu.Unlock()
+
+	if r == nil {
+		return fmt.Errorf("bus is not recording")
+	}
+	return b.RemoveInterceptor(r.id)
+}
+
+// intercept records a message and passes it on
+func (r *recorder) intercept(topic string, msg device.Message, next func(device.Message) error) error {
+	line := recordLine{
+		Time:          r.now(),
+		Topic:         topic,
+		ID:            msg.ID,
+		Values:        msg.Values,
+		Source:        msg.Source,
+		Destination:   msg.Destination,
+		CorrelationID: msg.CorrelationID,
+		ReplyTo:       msg.ReplyTo,
+		Error:         msg.Error,
+	}
+	if !msg.Time.IsZero() {
+		line.Stamp = msg.Time.UnixNano()
+	}
+
+	r.mu.Lock()
+	err := r.enc.Encode(line)
+	r.mu.Unlock()
+	if err != nil {
+		log.Printf("Error recording bus message %s: %v", msg.ID, err)
+	}
+	return next(msg)
+}
+
+// RecordReader reads the records of a recording in order
+type RecordReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewRecordReader reads a recording written by StartRecording
+func NewRecordReader(r io.Reader) *RecordReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	return &RecordReader{scanner: scanner}
+}
+
+// Next returns the next record, io.EOF at the end of the recording
+// Values come back as decoded from JSON, numbers as float64
+func (r *RecordReader) Next() (Record, error) {
+	for r.scanner.Scan() {
+		r.line++
+		if len(r.scanner.Bytes()) == 0 {
+			continue
+		}
+		var line recordLine
+		if err := json.Unmarshal(r.scanner.Bytes(), &line); err != nil {
+			return Record{}, fmt.Errorf("recording line %d: %w", r.line, err)
+		}
+		rec := Record{
+			Time:  line.Time,
+			Topic: line.Topic,
+			Message: device.Message{
+				ID:            line.ID,
+				Values:        line.Values,
+				Source:        line.Source,
+				Destination:   line.Destination,
+				CorrelationID: line.CorrelationID,
+				ReplyTo:       line.ReplyTo,
+				Error:         line.Error,
+			},
+		}
+		if line.Stamp != 0 {
+			rec.Message.Time = time.Unix(0, line.Stamp)
+		}
+		return rec, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return Record{}, fmt.Errorf("reading recording: %w", err)
+	}
+	return Record{}, io.EOF
+}
+
+// SetReplaying switches replay on or off
+// While replaying, messages published by devices are dropped so only the
+// messages passed to Replay reach the subscribers.
+func (b *MessageBus) SetReplaying(on bool) {
+	b.replaying.Store(on)
+}
+
+// Replay publishes a recorded message, also while live messages are
+// dropped
+func (b *MessageBus) Replay(rec Record) error {
+	if err := validateTopic(rec.Topic); err != nil {
+		return err
+	}
+	return b.publishChain(rec.Topic, rec.Message)
+}
@@ -0,0 +1,107 @@
+package bus
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"spacecraftsim/internal/device"
+)
+
+// replyPrefix is the first level of the topics requests are answered on
+const replyPrefix = "_replies" + topicSeparator
+
+// pendingRequests holds the reply channels of requests waiting for an
+// answer by reply topic
+type pendingRequests struct {
+	mu      sync.Mutex
+	next    atomic.Uint64
+	waiting map[string]chan device.Message
+}
+
+// newPendingRequests creates an empty set of pending requests
+func newPendingRequests() *pendingRequests {
+	return &pendingRequests{waiting: make(map[string]chan device.Message)}
+}
+
+// open registers a new request and returns its reply topic
+func (p *pendingRequests) open() (string, chan device.Message) {
+	topic := replyPrefix + strconv.FormatUint(p.next.Add(1), 10)
+	ch := make(chan device.Message, 1)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.waiting[topic] = ch
+	return topic, ch
+}
+
+// close forgets a request, later replies are dropped
+func (p *pendingRequests) close(topic string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.waiting, topic)
+}
+
+// answer passes a reply to the request waiting on its topic and reports
+// whether there was one. Only the first reply is kept.
+func (p *pendingRequests) answer(topic string, msg device.Message) bool {
+	if !strings.HasPrefix(topic, replyPrefix) {
+		return false
+	}
+
+	p.mu.Lock()
+	ch, exists := p.waiting[topic]
+	p.mu.Unlock()
+	if !exists {
+		return false
+	}
+	select {
+	case ch <- msg:
+	default:
+	}
+	return true
+}
+
+// Request publishes a request and waits up to timeout for its reply
+// The request gets a reply topic of its own and a correlation ID unless it
+// has one. A request nobody subscribes to fails at once; a reply reporting
+// an error is returned with that error.
+func (b *MessageBus) Request(topic string, msg device.Message, timeout time.Duration) (device.Message, error) {
+	if err := validateTopic(topic); err != nil {
+		return device.Message{}, err
+	}
+
+	replyTo, ch := b.pending.open()
+	defer b.pending.close(replyTo)
+	msg.ReplyTo = replyTo
+	if msg.CorrelationID == "" {
+		msg.CorrelationID = strings.TrimPrefix(replyTo, replyPrefix)
+	}
+
+	matched := make(map[device.Device]struct{})
+	b.mu.RLock()
+	b.topics.match(topic, matched)
+	b.mu.RUnlock()
+	if len(matched) == 0 {
+		return device.Message{}, &device.NoReplyError{ID: msg.ID, Timeout: timeout}
+	}
+
+	if err := b.Publish(topic, msg); err != nil {
+		return device.Message{}, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case reply := <-ch:
+		if reply.Error != "" {
+			return reply, fmt.Errorf("%s: %s", reply.Source, reply.Error)
+		}
+		return reply, nil
+	case <-timer.C:
+		return device.Message{}, &device.NoReplyError{ID: msg.ID, Timeout: timeout}
+	}
+}
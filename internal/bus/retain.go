@@ -0,0 +1,96 @@
+package bus
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"spacecraftsim/internal/device"
+)
+
+// Retained is the last message published with an ID on a topic
+type Retained struct {
+	Topic   string
+	Message device.Message
+}
+
+// retainCache keeps the last message of every ID on the retained topics
+type retainCache struct {
+	mu       sync.RWMutex
+	filters  []string                             // topics to retain, may contain wildcards
+	messages map[string]map[string]device.Message // by topic and message ID
+}
+
+// newRetainCache creates an empty cache retaining no topics
+func newRetainCache() *retainCache {
+	return &retainCache{messages: make(map[string]map[string]device.Message)}
+}
+
+// retain adds topic filters to retain
+func (c *retainCache) retain(filters ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.filters = append(c.filters, filters...)
+}
+
+// store keeps a message published on a retained topic
+func (c *retainCache) store(topic string, msg device.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	retained := false
+	for _, filter := range c.filters {
+		if matchFilter(filter, topic) {
+			retained = true
+			break
+		}
+	}
+	if !retained {
+		return
+	}
+	if c.messages[topic] == nil {
+		c.messages[topic] = make(map[string]device.Message)
+	}
+	c.messages[topic][msg.ID] = msg
+}
+
+// match returns the retained messages on topics matching a filter ordered
+// by topic and ID
+func (c *retainCache) match(filter string) []Retained {
+	c.mu.RLock()
+	var out []Retained
+	for topic, msgs := range c.messages {
+		if !matchFilter(filter, topic) {
+			continue
+		}
+		for _, msg := range msgs {
+			out = append(out, Retained{Topic: topic, Message: msg})
+		}
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Topic != out[j].Topic {
+			return out[i].Topic < out[j].Topic
+		}
+		return out[i].Message.ID < out[j].Message.ID
+	})
+	return out
+}
+
+// matchFilter reports whether a topic matches a subscription filter
+func matchFilter(filter, topic string) bool {
+	levels := strings.Split(topic, topicSeparator)
+	filters := strings.Split(filter, topicSeparator)
+	for i, f := range filters {
+		switch {
+		case f == multiLevel:
+			return true
+		case i >= len(levels):
+			return false
+		case f != singleLevel && f != levels[i]:
+			return false
+		}
+	}
+	return len(filters) == len(levels)
+}
@@ -0,0 +1,139 @@
+package bus
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"spacecraftsim/internal/device"
+)
+
+// rateWindow is the period message rates are averaged over
+const rateWindow = 10 * time.Second
+
+// timeBytes is the size counted for the time of a message
+const timeBytes = 8
+
+// TopicStats describes the traffic on a topic
+// Sizes are estimated from the string fields and the values of the
+// messages without encoding them. Rates are averaged
+// over the last complete window of 10 seconds of wall time.
+type TopicStats struct {
+	Topic       string  `json:"topic"`
+	Messages    uint64  `json:"messages"`
+	Bytes       uint64  `json:"bytes"`
+	MaxSize     int     `json:"max_size"`
+	Rate        float64 `json:"rate"`        // messages per second
+	ByteRate    float64 `json:"byte_rate"`   // bytes per second
+	Subscribers int     `json:"subscribers"` // devices the last message was sent to
+}
+
+// topicCounter counts the messages published on a topic
+type topicCounter struct {
+	TopicStats
+	windowStart    time.Time
+	windowMessages uint64
+	windowBytes    uint64
+}
+
+// roll closes the rate window once it is over
+// Without messages for a whole window the rates drop to zero.
+func (c *topicCounter) roll(now time.Time) {
+	elapsed := now.Sub(c.windowStart)
+	if elapsed < rateWindow {
+		return
+	}
+	if elapsed >= 2*rateWindow {
+		c.Rate, c.ByteRate = 0, 0
+	} else {
+		c.Rate = float64(c.windowMessages) / elapsed.Seconds()
+		c.ByteRate = float64(c.windowBytes) / elapsed.Seconds()
+	}
+	c.windowStart = now
+	c.windowMessages, c.windowBytes = 0, 0
+}
+
+// topicStats counts the traffic of every topic published on
+type topicStats struct {
+	mu     sync.Mutex
+	topics map[string]*topicCounter
+}
+
+// newTopicStats creates empty topic statistics
+func newTopicStats() *topicStats {
+	return &topicStats{topics: make(map[string]*topicCounter)}
+}
+
+// record counts a message published on a topic and sent to a number of
+// subscribers
+func (s *topicStats) record(topic string, msg device.Message, subscribers int) {
+	size := messageSize(msg)
+	now := time.Now()
+	if strings.HasPrefix(topic, replyPrefix) {
+		// Every request has a reply topic of its own
+		topic = replyPrefix + "+"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, exists := s.topics[topic]
+	if !exists {
+		c = &topicCounter{TopicStats: TopicStats{Topic: topic}, windowStart: now}
+		s.topics[topic] = c
+	}
+	c.roll(now)
+	c.Messages++
+	c.Bytes += uint64(size)
+	if size > c.MaxSize {
+		c.MaxSize = size
+	}
+	c.Subscribers = subscribers
+	c.windowMessages++
+	c.windowBytes += uint64(size)
+}
+
+// messageSize estimates the size of a message from its fields without
+// encoding it
+func messageSize(msg device.Message) int {
+	size := len(msg.ID) + len(msg.Source) + len(msg.Destination) +
+		len(msg.CorrelationID) + len(msg.ReplyTo) + len(msg.Error)
+	return size + timeBytes + device.PayloadBytes(msg)
+}
+
+// list returns the statistics of every topic ordered by topic
+func (s *topicStats) list() []TopicStats {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make([]TopicStats, 0, len(s.topics))
+	for _, c := range s.topics {
+		c.roll(now)
+		stats = append(stats, c.TopicStats)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Topic < stats[j].Topic })
+	return stats
+}
+
+// TopicStats returns the traffic of every topic published on ordered by
+// topic, the replies to requests counted together as "_replies/+"
+func (b *MessageBus) TopicStats() []TopicStats {
+	return b.stats.list()
+}
+
+// Subscriptions returns the number of subscribers of every topic filter
+func (b *MessageBus) Subscriptions() map[string]int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, filters := range b.subscribers {
+		for filter := range filters {
+			counts[filter]++
+		}
+	}
+	return counts
+}
@@ -0,0 +1,120 @@
+package bus
+
+import (
+	"fmt"
+	"strings"
+
+	"spacecraftsim/internal/device"
+)
+
+const (
+	// topicSeparator separates the levels of a topic, e.g. "thermal/node3/temp"
+	topicSeparator = "/"
+	// singleLevel matches exactly one level in a subscription, e.g.
+	// "thermal/+/temp"
+	singleLevel = "+"
+	// multiLevel matches the parent level and any number of levels below
+	// it, e.g. "thermal/#"; it must be the last level of a subscription
+	multiLevel = "#"
+)
+
+// validateTopic checks a topic messages are published on
+func validateTopic(topic string) error {
+	if topic == "" {
+		return fmt.Errorf("topic cannot be empty")
+	}
+	if strings.ContainsAny(topic, singleLevel+multiLevel) {
+		return fmt.Errorf("invalid topic %q: wildcards are only allowed in subscriptions", topic)
+	}
+	return nil
+}
+
+// validateFilter checks a subscription topic, which may contain wildcards
+func validateFilter(filter string) error {
+	if filter == "" {
+		return fmt.Errorf("topic cannot be empty")
+	}
+	levels := strings.Split(filter, topicSeparator)
+	for i, level := range levels {
+		if level == singleLevel || (level == multiLevel && i == len(levels)-1) {
+			continue
+		}
+		if strings.ContainsAny(level, singleLevel+multiLevel) {
+			return fmt.Errorf("invalid topic %q: wildcards must fill a whole level and # must be last", filter)
+		}
+	}
+	return nil
+}
+
+// topicNode is a level of the subscription trie
+type topicNode struct {
+	children    map[string]*topicNode
+	subscribers map[device.Device]struct{}
+}
+
+// newTopicNode creates an empty trie node
+func newTopicNode() *topicNode {
+	return &topicNode{
+		children:    make(map[string]*topicNode),
+		subscribers: make(map[device.Device]struct{}),
+	}
+}
+
+// add subscribes a device to a topic filter
+func (n *topicNode) add(filter string, dev device.Device) {
+	for _, level := range strings.Split(filter, topicSeparator) {
+		child, exists := n.children[level]
+		if !exists {
+			child = newTopicNode()
+			n.children[level] = child
+		}
+		n = child
+	}
+	n.subscribers[dev] = struct{}{}
+}
+
+// remove unsubscribes a device from a topic filter and prunes the levels
+// left without subscribers
+func (n *topicNode) remove(filter string, dev device.Device) {
+	n.removeLevels(strings.Split(filter, topicSeparator), dev)
+}
+
+// removeLevels removes a subscription below the node and reports whether
+// the node became empty
+func (n *topicNode) removeLevels(levels []string, dev device.Device) bool {
+	if len(levels) == 0 {
+		delete(n.subscribers, dev)
+	} else if child, exists := n.children[levels[0]]; exists {
+		if child.removeLevels(levels[1:], dev) {
+			delete(n.children, levels[0])
+		}
+	}
+	return len(n.subscribers) == 0 && len(n.children) == 0
+}
+
+// match adds the devices subscribed to filters matching a topic to subs
+func (n *topicNode) match(topic string, subs map[device.Device]struct{}) {
+	n.matchLevels(strings.Split(topic, topicSeparator), subs)
+}
+
+// matchLevels collects the subscribers matching the remaining levels
+func (n *topicNode) matchLevels(levels []string, subs map[device.Device]struct{}) {
+	// "#" also matches the parent level, "sensors/#" matches "sensors"
+	if child, exists := n.children[multiLevel]; exists {
+		for dev := range child.subscribers {
+			subs[dev] = struct{}{}
+		}
+	}
+	if len(levels) == 0 {
+		for dev := range n.subscribers {
+			subs[dev] = struct{}{}
+		}
+		return
+	}
+	if child, exists := n.children[levels[0]]; exists {
+		child.matchLevels(levels[1:], subs)
+	}
+	if child, exists := n.children[singleLevel]; exists {
+		child.matchLevels(levels[1:], subs)
+	}
+}
@@ -0,0 +1,696 @@
+// Package can emulates a CAN network segment
+// Nodes queue frames in their transmit buffers and the frame with the
+// lowest identifier wins arbitration whenever the bus is idle. Frames take
+// the time their bits need at the configured bitrates, so bus load,
+// arbitration delays and priority inversion behind a node's queue show up
+// in the latencies. Transmission errors raise the error counters of a node
+// until it goes bus-off.
+package can
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"spacecraftsim/internal/device"
+	"spacecraftsim/internal/fieldbus"
+)
+
+// Defaults of a CAN segment
+const (
+	DefaultBitrate     = 500000  // bit/s
+	DefaultDataBitrate = 2000000 // bit/s in the CAN FD data phase
+	DefaultQueueSize   = 32      // frames in a node transmit queue
+
+	// MaxStandardID and MaxExtendedID are the highest 11 and 29 bit
+	// identifiers
+	MaxStandardID = 0x7FF
+	MaxExtendedID = 0x1FFFFFFF
+
+	// tickRate is how often the segment arbitrates, the ship step
+	tickRate = 10 * time.Millisecond
+
+	// errorFrameBits is the length of an error frame with the following
+	// intermission
+	errorFrameBits = 23
+	// recoveryBits is the bus idle time a bus-off node waits before it
+	// recovers: 128 occurrences of 11 recessive bits
+	recoveryBits = 128 * 11
+)
+
+// Error states of a node
+const (
+	ErrorActive  = "error_active"
+	ErrorPassive = "error_passive"
+	BusOff       = "bus_off"
+)
+
+// fdSizes are the payload sizes a CAN FD frame can carry
+var fdSizes = []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 12, 16, 20, 24, 32, 48, 64}
+
+// Frame maps the messages a node publishes on a topic to a CAN identifier
+type Frame struct {
+	Topic    string
+	ID       uint32
+	Extended bool // 29 bit identifier
+}
+
+// key orders frames by arbitration priority, lowest first
+// The base identifier is sent first; a standard frame beats an extended
+// frame with the same base identifier.
+func (f Frame) key() uint64 {
+	if f.Extended {
+		return uint64(f.ID>>18)<<30 | 1<<29 | uint64(f.ID&0x3FFFF)
+	}
+	return uint64(f.ID) << 30
+}
+
+// String formats the identifier as CAN tools do
+func (f Frame) String() string {
+	if f.Extended {
+		return fmt.Sprintf("0x%08X", f.ID)
+	}
+	return fmt.Sprintf("0x%03X", f.ID)
+}
+
+// validate checks the identifier range
+func (f Frame) validate() error {
+	if f.Topic == "" || strings.ContainsAny(f.Topic, "+#") {
+		return fmt.Errorf("frame %s needs a topic without wildcards", f)
+	}
+	if f.Extended && f.ID > MaxExtendedID || !f.Extended && f.ID > MaxStandardID {
+		return fmt.Errorf("identifier %s of %s out of range", f, f.Topic)
+	}
+	return nil
+}
+
+// NodeConfig holds a node of the segment and the devices behind it
+type NodeConfig struct {
+	Name      string
+	Devices   []string
+	Frames    []Frame
+	ErrorRate float64 // probability that a transmission fails, 0 to 1
+}
+
+// Config holds the segment bitrates and nodes
+type Config struct {
+	Bitrate     int  // nominal bit/s
+	FD          bool // CAN FD with up to 64 byte payloads
+	DataBitrate int  // bit/s of the FD data phase
+	QueueSize   int  // frames per node transmit queue, the oldest are dropped
+	AutoRecover bool // bus-off nodes recover after 128 x 11 idle bits
+	Nodes       []NodeConfig
+	// Gateway carries messages published on the ship bus to the devices
+	// of the segment subscribed to them, as frames of a gateway node
+	Gateway []Frame
+}
+
+// pendingFrame is a fragment of a message waiting in a transmit queue
+type pendingFrame struct {
+	frame  *frameState
+	msg    device.Message
+	seq    uint64 // message the fragment belongs to
+	bytes  int    // payload of the fragment
+	last   bool   // the message is complete once the fragment is sent
+	queued time.Time
+}
+
+// frameState is a frame identifier at runtime
+type frameState struct {
+	Frame
+	node       *node
+	channel    *fieldbus.Channel // gateway frames only
+	messages   int
+	latency    time.Duration
+	maxLatency time.Duration
+}
+
+// node is a CAN controller at runtime
+type node struct {
+	NodeConfig
+	gateway   bool
+	devices   map[string]bool
+	frames    map[string]*frameState // by topic
+	queue     []pendingFrame         // FIFO transmit buffer
+	tec       int                    // transmit error counter
+	state     string
+	recoverAt time.Time // automatic recovery from bus-off
+	sent      int
+	errors    int
+	dropped   int
+}
+
+// Segment is a CAN bus segment
+// Devices behind a node publish through the node's view of the bus: their
+// messages on the node's frame topics are sent as frames on the segment
+// and continue on the downstream bus once the last fragment is through.
+// Messages on other topics bypass the segment. The segment reports itself
+// degraded while a node is error passive or bus-off.
+type Segment struct {
+	*device.BaseDevice
+	cfg        Config
+	downstream device.Bus
+	mu         sync.Mutex
+	nodes      []*node
+	gateway    *node
+	lastTick   time.Time
+	busyUntil  time.Time // end of the frame on the bus
+
+	busy        time.Duration // bus time in the current load window
+	window      time.Duration
+	load        float64 // load of the last complete window
+	maxLoad     float64
+	frames      int
+	errorFrames int
+	unmapped    int
+	seq         uint64 // messages queued
+}
+
+// loadWindow is the period bus load is averaged over
+const loadWindow = time.Second
+
+// New creates a CAN segment carrying messages to and from the downstream
+// bus
+func New(id string, downstream device.Bus, cfg Config) (*Segment, error) {
+	if cfg.Bitrate == 0 {
+		cfg.Bitrate = DefaultBitrate
+	}
+	if cfg.DataBitrate == 0 {
+		cfg.DataBitrate = DefaultDataBitrate
+	}
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = DefaultQueueSize
+	}
+	if cfg.Bitrate < 0 || cfg.DataBitrate < 0 || cfg.QueueSize < 0 {
+		return nil, fmt.Errorf("can %s: bitrates and queue size cannot be negative", id)
+	}
+
+	s := &Segment{
+		BaseDevice: device.NewBaseDevice(id, tickRate),
+		cfg:        cfg,
+		downstream: downstream,
+	}
+	ids := make(map[uint64]string)
+	names := make(map[string]bool)
+	attached := make(map[string]bool)
+	add := func(cfg NodeConfig, gateway bool) (*node, error) {
+		if cfg.Name == "" || names[cfg.Name] {
+			return nil, fmt.Errorf("can %s: missing or duplicate node name %q", id, cfg.Name)
+		}
+		names[cfg.Name] = true
+		if cfg.ErrorRate < 0 || cfg.ErrorRate > 1 {
+			return nil, fmt.Errorf("can %s: node %s: error rate must be between 0 and 1", id, cfg.Name)
+		}
+		n := &node{
+			NodeConfig: cfg,
+			gateway:    gateway,
+			devices:    make(map[string]bool),
+			frames:     make(map[string]*frameState),
+			state:      ErrorActive,
+		}
+		for _, dev := range cfg.Devices {
+			if attached[dev] {
+				return nil, fmt.Errorf("can %s: device %s is behind two nodes", id, dev)
+			}
+			attached[dev] = true
+			n.devices[dev] = true
+		}
+		for _, f := range cfg.Frames {
+			if err := f.validate(); err != nil {
+				return nil, fmt.Errorf("can %s: node %s: %w", id, cfg.Name, err)
+			}
+			if other, used := ids[f.key()]; used {
+				return nil, fmt.Errorf("can %s: identifier %s is used by %s and %s", id, f, other, cfg.Name)
+			}
+			ids[f.key()] = cfg.Name
+			if n.frames[f.Topic] != nil {
+				return nil, fmt.Errorf("can %s: node %s has two frames for %s", id, cfg.Name, f.Topic)
+			}
+			fs := &frameState{Frame: f, node: n}
+			if gateway {
+				fs.channel = &fieldbus.Channel{
+					Proxy: fmt.Sprintf("%s.gateway.%s", id, strings.TrimPrefix(f.String(), "0x")),
+					Carry: func(msg device.Message) { s.carry(fs, msg) },
+				}
+			}
+			n.frames[f.Topic] = fs
+		}
+		s.nodes = append(s.nodes, n)
+		return n, nil
+	}
+	for _, nc := range cfg.Nodes {
+		if _, err := add(nc, false); err != nil {
+			return nil, err
+		}
+	}
+	if len(cfg.Gateway) > 0 {
+		gw, err := add(NodeConfig{Name: "gateway", Frames: cfg.Gateway}, true)
+		if err != nil {
+			return nil, err
+		}
+		s.gateway = gw
+	}
+	return s, nil
+}
+
+// Node returns the bus as seen by the devices behind a node
+// Their messages on the node's frame topics are sent as frames, and they
+// receive the gateway topics through the gateway frames.
+func (s *Segment) Node(name string) (device.Bus, error) {
+	var n *node
+	for _, candidate := range s.nodes {
+		if candidate.Name == name && !candidate.gateway {
+			n = candidate
+		}
+	}
+	if n == nil {
+		return nil, fmt.Errorf("can %s: unknown node %s", s.ID(), name)
+	}
+	return &fieldbus.Port{
+		Name:       "can " + s.ID(),
+		Downstream: s.downstream,
+		Lock:       &s.mu,
+		Send: func(topic string, msg device.Message) bool {
+			if f := n.frames[topic]; f != nil {
+				s.enqueue(f, msg)
+				return true
+			}
+			s.mu.Lock()
+			s.unmapped++
+			s.mu.Unlock()
+			return false
+		},
+		Channel: func(topic string) *fieldbus.Channel {
+			if s.gateway == nil {
+				return nil
+			}
+			if f := s.gateway.frames[topic]; f != nil {
+				return f.channel
+			}
+			return nil
+		},
+	}, nil
+}
+
+// carry queues a message from the downstream bus on a gateway frame,
+// messages sent by the devices of the segment are already on it
+func (s *Segment) carry(f *frameState, msg device.Message) {
+	for _, n := range s.nodes {
+		if n.devices[msg.Source] {
+			return
+		}
+	}
+	s.enqueue(f, msg)
+}
+
+// maxPayload returns the payload bytes of a frame
+func (s *Segment) maxPayload() int {
+	if s.cfg.FD {
+		return 64
+	}
+	return 8
+}
+
+// enqueue splits a message into frames and stores them in the transmit
+// queue of their node
+// A full queue drops its oldest messages with all their fragments to make
+// room for every fragment of the message; a message with more fragments
+// than the queue holds is dropped.
+func (s *Segment) enqueue(f *frameState, msg device.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := f.node
+	size := device.PayloadBytes(msg)
+	frames := (size + s.maxPayload() - 1) / s.maxPayload()
+	if frames == 0 {
+		frames = 1
+	}
+	if frames > s.cfg.QueueSize {
+		n.dropped++
+		log.Printf("CAN %s node %s dropped %s: %d frames exceed the queue of %d", s.ID(), n.Name, msg.ID, frames, s.cfg.QueueSize)
+		return
+	}
+	for len(n.queue)+frames > s.cfg.QueueSize {
+		n.dropOldest()
+	}
+
+	now := s.Now()
+	s.seq++
+	for i := 0; i < frames; i++ {
+		bytes := s.maxPayload()
+		if i == frames-1 {
+			bytes = size - i*s.maxPayload()
+		}
+		n.queue = append(n.queue, pendingFrame{frame: f, msg: msg, seq: s.seq, bytes: bytes, last: i == frames-1, queued: now})
+	}
+}
+
+// dropOldest removes the fragments of the oldest message in the queue
+func (n *node) dropOldest() {
+	seq := n.queue[0].seq
+	for len(n.queue) > 0 && n.queue[0].seq == seq {
+		n.queue = n.queue[1:]
+	}
+	n.dropped++
+}
+
+// Tick arbitrates the frames queued since the last tick and delivers the
+// messages whose last fragment was sent
+func (s *Segment) Tick() error {
+	now := s.Now()
+
+	s.mu.Lock()
+	if s.lastTick.IsZero() {
+		s.lastTick = now
+	}
+	cursor := s.lastTick
+	if s.busyUntil.After(cursor) {
+		cursor = s.busyUntil
+	}
+
+	var done []pendingFrame
+	for cursor.Before(now) {
+		s.recover(cursor)
+		winner, next := s.arbitrate(cursor)
+		if winner == nil {
+			// Idle until the next frame is queued
+			if next.IsZero() || !next.Before(now) {
+				break
+			}
+			cursor = next
+			continue
+		}
+
+		head := winner.queue[0]
+		bits := s.frameTime(head.frame.Frame, head.bytes)
+		if winner.ErrorRate > 0 && rand.Float64() < winner.ErrorRate {
+			// The frame is destroyed by an error frame and retransmitted
+			cursor = cursor.Add(bits + s.nominalBits(errorFrameBits))
+			s.busy += bits + s.nominalBits(errorFrameBits)
+			s.errorFrames++
+			winner.errors++
+			s.transmitError(winner, cursor)
+			continue
+		}
+
+		cursor = cursor.Add(bits)
+		s.busy += bits
+		s.frames++
+		winner.sent++
+		if winner.tec > 0 {
+			winner.tec--
+		}
+		if winner.state == ErrorPassive && winner.tec < 128 {
+			winner.state = ErrorActive
+		}
+		winner.queue = winner.queue[1:]
+		if head.last {
+			f := head.frame
+			latency := cursor.Sub(head.queued)
+			f.messages++
+			f.latency += latency
+			if latency > f.maxLatency {
+				f.maxLatency = latency
+			}
+			done = append(done, head)
+		}
+	}
+	s.busyUntil = cursor
+	s.recover(now)
+
+	s.window += now.Sub(s.lastTick)
+	s.lastTick = now
+	if s.window >= loadWindow {
+		s.load = float64(s.busy) / float64(s.window)
+		if s.load > s.maxLoad {
+			s.maxLoad = s.load
+		}
+		s.busy, s.window = 0, 0
+	}
+
+	receivers := make(map[*frameState][]device.Device)
+	for _, d := range done {
+		if d.frame.node.gateway {
+			receivers[d.frame] = d.frame.channel.Receivers()
+		}
+	}
+	s.mu.Unlock()
+
+	// Deliver without the lock, receivers may publish in response
+	for _, d := range done {
+		if !d.frame.node.gateway {
+			if err := s.downstream.Publish(d.frame.Topic, d.msg); err != nil {
+				log.Printf("can %s: error publishing %s from %s: %v", s.ID(), d.msg.ID, d.frame.node.Name, err)
+			}
+			continue
+		}
+		fieldbus.Deliver("can "+s.ID(), receivers[d.frame], d.msg)
+	}
+	return nil
+}
+
+// arbitrate returns the node whose queue head has the lowest identifier
+// among the frames queued by a time, nil when no node has a frame to
+// send; next is then when the next frame was queued, zero if none is
+// Only the head of a queue competes, so a low priority frame at the head
+// holds back the high priority frames behind it.
+// The caller must hold s.mu
+func (s *Segment) arbitrate(at time.Time) (winner *node, next time.Time) {
+	for _, n := range s.nodes {
+		if n.state == BusOff || len(n.queue) == 0 {
+			continue
+		}
+		head := n.queue[0]
+		if head.queued.After(at) {
+			if next.IsZero() || head.queued.Before(next) {
+				next = head.queued
+			}
+			continue
+		}
+		if winner == nil || head.frame.key() < winner.queue[0].frame.key() {
+			winner = n
+		}
+	}
+	return winner, next
+}
+
+// transmitError counts a failed transmission of a node
+// The caller must hold s.mu
+func (s *Segment) transmitError(n *node, now time.Time) {
+	n.tec += 8
+	switch {
+	case n.tec > 255 && n.state != BusOff:
+		n.state = BusOff
+		if s.cfg.AutoRecover {
+			n.recoverAt = now.Add(s.nominalBits(recoveryBits))
+		}
+		log.Printf("CAN %s node %s is bus-off", s.ID(), n.Name)
+	case n.tec > 127 && n.state == ErrorActive:
+		n.state = ErrorPassive
+		log.Printf("CAN %s node %s is error passive", s.ID(), n.Name)
+	}
+}
+
+// recover brings bus-off nodes whose recovery time has come back
+// The caller must hold s.mu
+func (s *Segment) recover(now time.Time) {
+	for _, n := range s.nodes {
+		if n.state == BusOff && !n.recoverAt.IsZero() && !now.Before(n.recoverAt) {
+			s.reset(n)
+			log.Printf("CAN %s node %s recovered from bus-off", s.ID(), n.Name)
+		}
+	}
+}
+
+// reset returns a node to error active
+// The caller must hold s.mu
+func (s *Segment) reset(n *node) {
+	n.state = ErrorActive
+	n.tec = 0
+	n.recoverAt = time.Time{}
+}
+
+// nominalBits returns the time of bits at the nominal bitrate
+func (s *Segment) nominalBits(bits int) time.Duration {
+	return time.Duration(bits) * time.Second / time.Duration(s.cfg.Bitrate)
+}
+
+// frameTime returns the time a frame with a payload of a number of bytes
+// occupies the bus, with worst case bit stuffing
+// CAN FD frames send the payload and CRC at the data bitrate.
+func (s *Segment) frameTime(f Frame, bytes int) time.Duration {
+	if !s.cfg.FD {
+		overhead, stuffed := 47, 34 // SOF to intermission, stuffed part
+		if f.Extended {
+			overhead, stuffed = 67, 54
+		}
+		return s.nominalBits(overhead + 8*bytes + (stuffed+8*bytes-1)/4)
+	}
+
+	for _, size := range fdSizes {
+		if size >= bytes {
+			bytes = size
+			break
+		}
+	}
+	arbitration := 17 // SOF, identifier, RRS, IDE, FDF, res, BRS
+	if f.Extended {
+		arbitration = 36
+	}
+	arbitration += arbitration / 4
+	crc := 17
+	if bytes > 16 {
+		crc = 21
+	}
+	data := 5 + 8*bytes + crc + 5 // ESI, DLC, payload, CRC, stuff count
+	data += data / 4
+	tail := 13 // CRC delimiter, ACK, EOF and intermission
+	return s.nominalBits(arbitration+tail) +
+		time.Duration(data)*time.Second/time.Duration(s.cfg.DataBitrate)
+}
+
+// Health reports the segment degraded while a node is error passive or
+// bus-off
+func (s *Segment) Health() device.Health {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var off, passive []string
+	for _, n := range s.nodes {
+		switch n.state {
+		case BusOff:
+			off = append(off, n.Name)
+		case ErrorPassive:
+			passive = append(passive, n.Name)
+		}
+	}
+	if len(off) > 0 {
+		return device.Health{Status: device.HealthDegraded, Code: "node_bus_off", Reason: "bus-off: " + strings.Join(off, ", ")}
+	}
+	if len(passive) > 0 {
+		return device.Health{Status: device.HealthDegraded, Code: "node_error_passive", Reason: "error passive: " + strings.Join(passive, ", ")}
+	}
+	return device.Health{Status: device.HealthNominal}
+}
+
+// HandleInput executes segment commands
+func (s *Segment) HandleInput(msg device.Message) error {
+	if msg.ID != s.ID() {
+		return nil
+	}
+	_, err := s.Query(msg)
+	return err
+}
+
+// Query executes segment commands and returns the segment status
+// Commands: ["status"], ["errors", node, rate] for the probability that a
+// transmission of the node fails, ["bus_off", node] and ["recover", node].
+// Latencies are in milliseconds.
+func (s *Segment) Query(msg device.Message) ([]interface{}, error) {
+	if len(msg.Values) == 0 {
+		return nil, fmt.Errorf("can %s: missing command", s.ID())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cmd := strings.ToLower(fmt.Sprint(msg.Values[0]))
+	if cmd != "status" {
+		if len(msg.Values) < 2 {
+			return nil, fmt.Errorf("can %s: %s needs a node", s.ID(), cmd)
+		}
+		n := s.node(fmt.Sprint(msg.Values[1]))
+		if n == nil {
+			return nil, fmt.Errorf("can %s: unknown node %v", s.ID(), msg.Values[1])
+		}
+		switch cmd {
+		case "errors":
+			if len(msg.Values) != 3 {
+				return nil, fmt.Errorf("can %s: errors needs a node and a rate", s.ID())
+			}
+			rate, err := device.ToFloat(msg.Values[2])
+			if err != nil || rate < 0 || rate > 1 {
+				return nil, fmt.Errorf("can %s: error rate must be a number between 0 and 1", s.ID())
+			}
+			n.ErrorRate = rate
+		case "bus_off":
+			n.tec = 256
+			n.state = BusOff
+			if s.cfg.AutoRecover {
+				n.recoverAt = s.Now().Add(s.nominalBits(recoveryBits))
+			}
+			log.Printf("CAN %s node %s forced bus-off", s.ID(), n.Name)
+		case "recover":
+			s.reset(n)
+		default:
+			return nil, fmt.Errorf("can %s: unknown command %s", s.ID(), cmd)
+		}
+	}
+	return []interface{}{s.status()}, nil
+}
+
+// node returns a node by name, nil if unknown
+// The caller must hold s.mu
+func (s *Segment) node(name string) *node {
+	for _, n := range s.nodes {
+		if n.Name == name {
+			return n
+		}
+	}
+	return nil
+}
+
+// status describes the segment, its nodes and frames
+// The caller must hold s.mu
+func (s *Segment) status() map[string]interface{} {
+	nodes := make([]interface{}, 0, len(s.nodes))
+	var frames []*frameState
+	for _, n := range s.nodes {
+		nodes = append(nodes, map[string]interface{}{
+			"node":       n.Name,
+			"state":      n.state,
+			"tec":        n.tec,
+			"queued":     len(n.queue),
+			"sent":       n.sent,
+			"errors":     n.errors,
+			"dropped":    n.dropped,
+			"error_rate": n.ErrorRate,
+		})
+		for _, f := range n.frames {
+			frames = append(frames, f)
+		}
+	}
+	sort.Slice(frames, func(i, j int) bool { return frames[i].key() < frames[j].key() })
+
+	list := make([]interface{}, len(frames))
+	for i, f := range frames {
+		mean := 0.0
+		if f.messages > 0 {
+			mean = device.Milliseconds(f.latency) / float64(f.messages)
+		}
+		list[i] = map[string]interface{}{
+			"id":              f.String(),
+			"topic":           f.Topic,
+			"node":            f.node.Name,
+			"messages":        f.messages,
+			"mean_latency_ms": mean,
+			"max_latency_ms":  device.Milliseconds(f.maxLatency),
+		}
+	}
+	return map[string]interface{}{
+		"bitrate":      s.cfg.Bitrate,
+		"fd":           s.cfg.FD,
+		"load":         s.load,
+		"max_load":     s.maxLoad,
+		"frames":       s.frames,
+		"error_frames": s.errorFrames,
+		"unmapped":     s.unmapped,
+		"nodes":        nodes,
+		"identifiers":  list,
+	}
+}
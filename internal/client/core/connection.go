@@ -86,6 +86,15 @@ func (c *Connection) Close() error {
 	return c.conn.Close()
 }
 
+// RequestValues asks the server for the current value of every retained
+// parameter, answered by a "__values__" response
+func (c *Connection) RequestValues() error {
+	if _, err := fmt.Fprintln(c.conn, "__values__"); err != nil {
+		return fmt.Errorf("failed to request values: %w", err)
+	}
+	return nil
+}
+
 // StartHeartbeat starts sending heartbeat messages
 func (c *Connection) StartHeartbeat(interval time.Duration) {
 	go func() {
@@ -47,31 +47,69 @@ func New(conn *core.Connection, logger *core.Logger) *UI {
 
 	// Set up message handler
 	ui.conn.SetMessageHandler(func(msg core.Message) {
-		// Update control values based on received messages
-		for _, control := range ui.controls {
-			if control.GetID() == msg.ID && len(msg.Values) > 0 {
-				ui.app.QueueUpdateDraw(func() {
-					if strValue, ok := msg.Values[0].(string); ok {
-						control.SetValue(strValue)
-					}
-				})
-			}
-		}
+		ui.updateControls(msg.ID, msg.Values)
 		ui.logger.Log(core.LevelInfo, fmt.Sprintf("%s = %v", msg.ID, msg.Values))
 	})
 
 	// Set up response handler
 	ui.conn.SetResponseHandler(func(resp parser.ResponseMessage) {
-		if resp.Type == "error" {
+		if resp.Type == "success" && resp.ID == "__values__" {
+			ui.applyValues(resp.Values)
+			return
+		}
+		switch resp.Type {
+		case "error":
 			ui.app.QueueUpdateDraw(func() {
 				ui.logger.Log(core.LevelError, fmt.Sprintf("Error from server: %s", resp.Error))
 			})
+		case "queued":
+			ui.app.QueueUpdateDraw(func() {
+				ui.logger.Log(core.LevelInfo, resp.Error)
+			})
 		}
 	})
 
+	// Show the current ship state rather than defaults until values change
+	if err := ui.conn.RequestValues(); err != nil {
+		ui.logger.Log(core.LevelError, fmt.Sprintf("Failed to request current values: %v", err))
+	}
+
 	return ui
 }
 
+// updateControls shows the first value of a message in the controls of
+// its device
+func (ui *UI) updateControls(id string, values []interface{}) {
+	if len(values) == 0 {
+		return
+	}
+	for _, control := range ui.controls {
+		if control.GetID() == id {
+			control := control
+			ui.app.QueueUpdateDraw(func() {
+				control.SetValue(fmt.Sprint(values[0]))
+			})
+		}
+	}
+}
+
+// applyValues updates the controls from the current value table
+func (ui *UI) applyValues(table interface{}) {
+	entries, ok := table.([]interface{})
+	if !ok {
+		return
+	}
+	for _, entry := range entries {
+		e, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id, _ := e["id"].(string)
+		values, _ := e["values"].([]interface{})
+		ui.updateControls(id, values)
+	}
+}
+
 // Run starts the UI
 func (ui *UI) Run() error {
 	return ui.app.SetRoot(ui.grid, true).Run()
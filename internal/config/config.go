@@ -0,0 +1,663 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ContactPolicy defines what happens to client commands outside a contact window
+type ContactPolicy string
+
+const (
+	PolicyQueue  ContactPolicy = "queue"
+	PolicyReject ContactPolicy = "reject"
+)
+
+// OrbitConfig represents the simulated orbit
+// Angles are in degrees, altitude in km
+type OrbitConfig struct {
+	Altitude    float64 `yaml:"altitude"`
+	Inclination float64 `yaml:"inclination"`
+	RAAN        float64 `yaml:"raan"`
+	ArgLatitude float64 `yaml:"arg_latitude"`
+}
+
+// GroundStationConfig represents a ground station definition
+type GroundStationConfig struct {
+	ID            string        `yaml:"id"`
+	Latitude      float64       `yaml:"lat"`
+	Longitude     float64       `yaml:"lon"`
+	Altitude      float64       `yaml:"alt"`            // km
+	ElevationMask float64       `yaml:"elevation_mask"` // degrees
+	OutsideAOS    ContactPolicy `yaml:"outside_aos,omitempty"`
+}
+
+// DeviceType represents the type of a configured device
+type DeviceType string
+
+const (
+	TypePID          DeviceType = "pid"
+	TypeSensor       DeviceType = "sensor"
+	TypeRecorder     DeviceType = "recorder"
+	TypeEcho         DeviceType = "echo"
+	TypeStateMachine DeviceType = "state_machine"
+	TypeSwitch       DeviceType = "switch"
+)
+
+// SwitchConfig represents a power switch or distribution channel
+type SwitchConfig struct {
+	On *bool `yaml:"on,omitempty"` // initial state, on when unset
+}
+
+// DependencyConfig states that a device depends on an upstream device
+// The effect applies while the upstream device is failed or off
+type DependencyConfig struct {
+	Device   string `yaml:"device"`
+	Upstream string `yaml:"upstream"`
+	Effect   string `yaml:"effect,omitempty"` // mute (default), fail or degrade
+}
+
+// StateConfig represents a state of a state machine
+type StateConfig struct {
+	Name      string         `yaml:"name"`
+	OnEntry   []SignalConfig `yaml:"on_entry,omitempty"`
+	OnExit    []SignalConfig `yaml:"on_exit,omitempty"`
+	Timeout   time.Duration  `yaml:"timeout,omitempty"`
+	TimeoutTo string         `yaml:"timeout_to,omitempty"`
+}
+
+// TransitionConfig represents an allowed transition of a state machine
+// Guards are "<parameter> <operator> <value>" expressions on watched values
+type TransitionConfig struct {
+	From   string   `yaml:"from"` // state name or "*" for any state
+	To     string   `yaml:"to"`
+	Guards []string `yaml:"guards,omitempty"`
+	Auto   bool     `yaml:"auto,omitempty"` // taken as soon as the guards hold
+}
+
+// StateMachineConfig represents the definition of a state machine device
+type StateMachineConfig struct {
+	Initial     string             `yaml:"initial,omitempty"` // defaults to the first state
+	Watch       []string           `yaml:"watch,omitempty"`   // topics providing guard values
+	States      []StateConfig      `yaml:"states"`
+	Transitions []TransitionConfig `yaml:"transitions"`
+}
+
+// BusConfig represents the delivery of messages on the device bus
+type BusConfig struct {
+	Delivery  string `yaml:"delivery,omitempty"`   // sync (default) or async
+	QueueSize int    `yaml:"queue_size,omitempty"` // messages per subscriber, default 256
+	// Overflow is drop_oldest (default), drop_newest or block; block
+	// deadlocks a subscriber publishing to its own full queue
+	Overflow string `yaml:"overflow,omitempty"`
+	// Retain lists the topics whose last values are kept for new
+	// subscribers and clients, defaults to the telemetry topics
+	Retain []string `yaml:"retain,omitempty"`
+	// Trace lists topics, possibly with wildcards, whose messages are
+	// logged as they are published
+	Trace []string `yaml:"trace,omitempty"`
+	// Record names the files bus messages are written to, recording
+	// from the start when set; clients switch recording with
+	// "__record__" and every recording gets a file of its own, the name
+	// with its start time appended
+	Record string `yaml:"record,omitempty"`
+	// Replay is a bus recording fed to the ship instead of running it live
+	Replay     string `yaml:"replay,omitempty"`
+	ReplayFast bool   `yaml:"replay_fast,omitempty"` // replay as fast as possible instead of at the original pace
+}
+
+// MetricsConfig represents the export of the runtime metrics
+type MetricsConfig struct {
+	// Listen is the HTTP address the Prometheus text format is served on
+	// at /metrics, e.g. ":9100"; clients query "__metrics__" either way
+	Listen string `yaml:"listen,omitempty"`
+}
+
+// ModeConfig represents an operating mode of the ship
+type ModeConfig struct {
+	StateConfig `yaml:",inline"`
+	Disabled    []string                 `yaml:"disabled,omitempty"`   // devices switched off in the mode
+	TickRates   map[string]time.Duration `yaml:"tick_rates,omitempty"` // by device
+	// Commands accepted from clients as "<device>" or "<device>:<command>",
+	// all commands are accepted when omitted
+	Commands []string `yaml:"commands,omitempty"`
+}
+
+// ModesConfig represents the ship modes and the transitions between them
+type ModesConfig struct {
+	Initial     string             `yaml:"initial,omitempty"` // defaults to the first mode
+	Watch       []string           `yaml:"watch,omitempty"`   // topics providing guard values
+	Modes       []ModeConfig       `yaml:"modes"`
+	Transitions []TransitionConfig `yaml:"transitions"`
+}
+
+// AvionicsBusConfig represents a MIL-STD-1553 style avionics bus
+// Devices behind its remote terminals exchange the scheduled topics
+// through it; timing fields default to a 1 Mbit/s bus with 50 minor
+// frames of 20ms
+type AvionicsBusConfig struct {
+	ID           string           `yaml:"id,omitempty"` // bus controller device, defaults to avionics_bus
+	MinorFrame   time.Duration    `yaml:"minor_frame,omitempty"`
+	MinorFrames  int              `yaml:"minor_frames,omitempty"` // per major frame
+	WordTime     time.Duration    `yaml:"word_time,omitempty"`
+	ResponseTime time.Duration    `yaml:"response_time,omitempty"`
+	Gap          time.Duration    `yaml:"gap,omitempty"`
+	QueueSize    int              `yaml:"queue_size,omitempty"` // messages waiting per subaddress
+	Terminals    []TerminalConfig `yaml:"terminals"`
+	Schedule     []TransferConfig `yaml:"schedule"`
+}
+
+// TerminalConfig represents a remote terminal and the devices behind it
+type TerminalConfig struct {
+	Address int      `yaml:"address"` // 0 to 30
+	Devices []string `yaml:"devices"`
+}
+
+// TransferConfig represents a scheduled transfer of a topic between the
+// bus controller and a terminal subaddress
+type TransferConfig struct {
+	Terminal   int    `yaml:"terminal"`
+	Subaddress int    `yaml:"subaddress"` // 1 to 30
+	Topic      string `yaml:"topic"`
+	Transmit   bool   `yaml:"transmit,omitempty"` // from the terminal, otherwise to it
+	Frames     []int  `yaml:"frames,omitempty"`   // minor frames it runs in, all when empty
+}
+
+// CANBusConfig represents a CAN bus segment
+// Devices behind its nodes send the topics of their node's frames as CAN
+// frames; bitrates default to 500 kbit/s and 2 Mbit/s in the FD data phase
+type CANBusConfig struct {
+	ID          string           `yaml:"id"` // segment device
+	Bitrate     int              `yaml:"bitrate,omitempty"`
+	FD          bool             `yaml:"fd,omitempty"`
+	DataBitrate int              `yaml:"data_bitrate,omitempty"`
+	QueueSize   int              `yaml:"queue_size,omitempty"` // frames per node transmit queue
+	AutoRecover bool             `yaml:"auto_recover,omitempty"`
+	Nodes       []CANNodeConfig  `yaml:"nodes"`
+	Gateway     []CANFrameConfig `yaml:"gateway,omitempty"` // ship bus topics sent to the segment
+}
+
+// CANNodeConfig represents a CAN node and the devices behind it
+type CANNodeConfig struct {
+	Name      string           `yaml:"name"`
+	Devices   []string         `yaml:"devices"`
+	Frames    []CANFrameConfig `yaml:"frames"`
+	ErrorRate float64          `yaml:"error_rate,omitempty"` // probability that a transmission fails
+}
+
+// CANFrameConfig maps a topic to a CAN identifier
+type CANFrameConfig struct {
+	Topic    string `yaml:"topic"`
+	ID       uint32 `yaml:"id"`
+	Extended bool   `yaml:"extended,omitempty"` // 29 bit identifier
+}
+
+// EchoConfig represents the behaviour of an echo device
+type EchoConfig struct {
+	Delay           time.Duration `yaml:"delay,omitempty"`
+	Transform       string        `yaml:"transform,omitempty"` // uppercase, lowercase, reverse, negate or scale
+	Scale           float64       `yaml:"scale,omitempty"`
+	LossProbability float64       `yaml:"loss_probability,omitempty"`
+}
+
+// RecorderConfig represents the parameters of a telemetry recorder device
+type RecorderConfig struct {
+	Topics   []string `yaml:"topics"`
+	Capacity int      `yaml:"capacity,omitempty"` // samples kept per parameter
+}
+
+// ErrorModelConfig represents one stage of a sensor error model chain
+// Only the fields used by the model type are read
+type ErrorModelConfig struct {
+	Type       string        `yaml:"type"` // gaussian, bias, drift, scale, quantize, saturate, latency, sample_hold
+	Sigma      float64       `yaml:"sigma,omitempty"`
+	Offset     float64       `yaml:"offset,omitempty"`
+	Rate       float64       `yaml:"rate,omitempty"`
+	RandomWalk float64       `yaml:"random_walk,omitempty"`
+	Factor     float64       `yaml:"factor,omitempty"`
+	Step       float64       `yaml:"step,omitempty"`
+	Min        float64       `yaml:"min,omitempty"`
+	Max        float64       `yaml:"max,omitempty"`
+	Delay      time.Duration `yaml:"delay,omitempty"`
+	Period     time.Duration `yaml:"period,omitempty"`
+}
+
+// SensorConfig represents the parameters of a sensor device
+type SensorConfig struct {
+	Initial float64            `yaml:"initial"`
+	Noise   float64            `yaml:"noise"`           // random walk step without a truth source
+	Truth   *SignalConfig      `yaml:"truth,omitempty"` // message providing the true value
+	Topic   string             `yaml:"topic,omitempty"` // published on, defaults to "sensors"
+	Errors  []ErrorModelConfig `yaml:"errors,omitempty"`
+	Publish *PublishConfig     `yaml:"publish,omitempty"`
+}
+
+// PublishConfig represents a sensor publish policy
+// Unset deadband and heartbeat keep the sensor defaults
+type PublishConfig struct {
+	Policy    string         `yaml:"policy"` // on_change, periodic or on_request
+	Period    time.Duration  `yaml:"period,omitempty"`
+	Deadband  *float64       `yaml:"deadband,omitempty"`
+	Heartbeat *time.Duration `yaml:"heartbeat,omitempty"`
+}
+
+// SignalConfig identifies a message on the bus
+type SignalConfig struct {
+	Topic  string        `yaml:"topic"`
+	ID     string        `yaml:"id"`
+	Values []interface{} `yaml:"values,omitempty"` // sent before an output value
+}
+
+// PIDConfig represents the parameters of a PID controller device
+type PIDConfig struct {
+	Measurement  SignalConfig `yaml:"measurement"`
+	Output       SignalConfig `yaml:"output"`
+	Setpoint     float64      `yaml:"setpoint"`
+	Kp           float64      `yaml:"kp"`
+	Ki           float64      `yaml:"ki"`
+	Kd           float64      `yaml:"kd"`
+	OutputMin    float64      `yaml:"output_min"`
+	OutputMax    float64      `yaml:"output_max"`
+	RateLimit    float64      `yaml:"rate_limit,omitempty"`    // per second
+	AntiWindup   string       `yaml:"anti_windup,omitempty"`   // none, clamp or back_calculation
+	TrackingGain float64      `yaml:"tracking_gain,omitempty"` // back-calculation gain
+}
+
+// HealthConfig represents the error counts that degrade or fail a device
+// Each error increments the count and each success decrements it
+// Unset thresholds keep the defaults, zero disables the level
+type HealthConfig struct {
+	DegradedAfter *int `yaml:"degraded_after,omitempty"`
+	FailedAfter   *int `yaml:"failed_after,omitempty"`
+}
+
+// FaultConfig represents a fault injected at a simulation time
+// The value is the stuck value, offset, drift rate per second, dropout
+// probability, noise sigma or delay in seconds depending on the type
+type FaultConfig struct {
+	At     time.Duration `yaml:"at"` // simulation time of injection
+	Device string        `yaml:"device"`
+	Type   string        `yaml:"type"` // stuck, offset, drift, dropout, noise, delay or failure
+	Value  *float64      `yaml:"value,omitempty"`
+	Param  string        `yaml:"param,omitempty"` // only this message ID
+	Inputs bool          `yaml:"inputs,omitempty"`
+	For    time.Duration `yaml:"for,omitempty"` // cleared after, unset until cleared
+}
+
+// RedundancyConfig represents a group of redundant units exposed as one
+// logical device
+type RedundancyConfig struct {
+	ID           string   `yaml:"id"`
+	Units        []string `yaml:"units"`                   // in failover order
+	Active       int      `yaml:"active,omitempty"`        // active units, 1 when unset
+	AutoFailover *bool    `yaml:"auto_failover,omitempty"` // enabled when unset
+}
+
+// ActionConfig represents a command issued by an FDIR rule
+type ActionConfig struct {
+	ID     string        `yaml:"id"`
+	Values []interface{} `yaml:"values"`
+	After  time.Duration `yaml:"after,omitempty"` // wait after the previous action
+}
+
+// FDIRRuleConfig represents a fault detection, isolation and recovery rule
+// The monitor is the fault condition, e.g. "cabin.ppo2 < 19.5", on a
+// parameter published on the topic
+type FDIRRuleConfig struct {
+	ID          string         `yaml:"id"`
+	Topic       string         `yaml:"topic"`
+	Monitor     string         `yaml:"monitor"`
+	Persistence int            `yaml:"persistence,omitempty"` // consecutive samples, 1 when unset
+	Isolation   []ActionConfig `yaml:"isolation,omitempty"`   // issued at once, without after
+	Recovery    []ActionConfig `yaml:"recovery,omitempty"`
+}
+
+// DeviceConfig represents a device built from configuration
+type DeviceConfig struct {
+	ID           string              `yaml:"id"`
+	Type         DeviceType          `yaml:"type"`
+	TickRate     time.Duration       `yaml:"tick_rate,omitempty"`
+	PID          *PIDConfig          `yaml:"pid,omitempty"`
+	Sensor       *SensorConfig       `yaml:"sensor,omitempty"`
+	Recorder     *RecorderConfig     `yaml:"recorder,omitempty"`
+	Echo         *EchoConfig         `yaml:"echo,omitempty"`
+	StateMachine *StateMachineConfig `yaml:"state_machine,omitempty"`
+	Switch       *SwitchConfig       `yaml:"switch,omitempty"`
+	Health       *HealthConfig       `yaml:"health,omitempty"`
+}
+
+// Config represents the server configuration
+type Config struct {
+	Devices        []DeviceConfig        `yaml:"devices"`
+	Orbit          OrbitConfig           `yaml:"orbit"`
+	GroundStations []GroundStationConfig `yaml:"ground_stations"`
+	// Health sets the default device health thresholds
+	Health *HealthConfig `yaml:"health,omitempty"`
+	// Faults are injected into devices during the run
+	Faults []FaultConfig `yaml:"faults,omitempty"`
+	// Dependencies propagate failures and power loss between devices
+	Dependencies []DependencyConfig `yaml:"dependencies,omitempty"`
+	// Redundancy declares primary/backup pairs and N-of-M groups
+	Redundancy []RedundancyConfig `yaml:"redundancy,omitempty"`
+	// FDIR rules run by the "fdir" device
+	FDIR []FDIRRuleConfig `yaml:"fdir,omitempty"`
+	// Bus sets how messages are delivered between devices
+	Bus *BusConfig `yaml:"bus,omitempty"`
+	// Metrics exports the bus, device and ship loop metrics
+	Metrics *MetricsConfig `yaml:"metrics,omitempty"`
+	// Modes are the operating modes of the ship run by the "mode" device
+	Modes *ModesConfig `yaml:"modes,omitempty"`
+	// AvionicsBus carries the scheduled topics of the devices behind its
+	// terminals with command/response timing
+	AvionicsBus *AvionicsBusConfig `yaml:"avionics_bus,omitempty"`
+	// CANBuses are CAN segments carrying the topics of the devices behind
+	// their nodes with arbitration and error frames
+	CANBuses []CANBusConfig `yaml:"can_buses,omitempty"`
+	// EventLog is a file every event is appended to as JSON lines
+	EventLog string `yaml:"event_log,omitempty"`
+	// DefaultStation ties newly connected clients to a ground station
+	// Clients without a station have a permanent link
+	DefaultStation string `yaml:"default_station,omitempty"`
+}
+
+// Load loads the server configuration from a YAML file
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// Empty returns the configuration of a server started without a
+// configuration file
+func Empty() *Config {
+	var config Config
+	if err := config.validate(); err != nil {
+		panic(fmt.Sprintf("empty configuration is invalid: %v", err))
+	}
+	return &config
+}
+
+// validate checks the configuration and fills in defaults
+func (c *Config) validate() error {
+	if len(c.GroundStations) > 0 && c.Orbit.Altitude <= 0 {
+		return fmt.Errorf("orbit altitude must be positive")
+	}
+
+	stations := make(map[string]bool)
+	for i := range c.GroundStations {
+		gs := &c.GroundStations[i]
+		if gs.ID == "" {
+			return fmt.Errorf("ground station ID cannot be empty")
+		}
+		if stations[gs.ID] {
+			return fmt.Errorf("duplicate ground station %s", gs.ID)
+		}
+		stations[gs.ID] = true
+		if gs.Latitude < -90 || gs.Latitude > 90 {
+			return fmt.Errorf("ground station %s: latitude out of range", gs.ID)
+		}
+		switch gs.OutsideAOS {
+		case "":
+			gs.OutsideAOS = PolicyQueue
+		case PolicyQueue, PolicyReject:
+		default:
+			return fmt.Errorf("ground station %s: unknown outside_aos policy %s", gs.ID, gs.OutsideAOS)
+		}
+	}
+
+	if err := c.Health.validate(); err != nil {
+		return fmt.Errorf("health: %w", err)
+	}
+
+	devices := make(map[string]bool)
+	for _, dev := range c.Devices {
+		if dev.ID == "" {
+			return fmt.Errorf("device ID cannot be empty")
+		}
+		if devices[dev.ID] {
+			return fmt.Errorf("duplicate device %s", dev.ID)
+		}
+		devices[dev.ID] = true
+		if dev.TickRate < 0 {
+			return fmt.Errorf("device %s: tick rate cannot be negative", dev.ID)
+		}
+		if err := dev.Health.validate(); err != nil {
+			return fmt.Errorf("device %s: health: %w", dev.ID, err)
+		}
+		switch dev.Type {
+		case TypePID:
+			if dev.PID == nil {
+				return fmt.Errorf("device %s: pid section is required", dev.ID)
+			}
+		case TypeSensor:
+			if dev.Sensor == nil {
+				return fmt.Errorf("device %s: sensor section is required", dev.ID)
+			}
+			if err := dev.Sensor.validate(); err != nil {
+				return fmt.Errorf("device %s: %w", dev.ID, err)
+			}
+		case TypeRecorder:
+			if dev.Recorder == nil || len(dev.Recorder.Topics) == 0 {
+				return fmt.Errorf("device %s: recorder needs at least one topic", dev.ID)
+			}
+			if dev.Recorder.Capacity < 0 {
+				return fmt.Errorf("device %s: recorder capacity cannot be negative", dev.ID)
+			}
+		case TypeEcho, TypeSwitch:
+		case TypeStateMachine:
+			if dev.StateMachine == nil || len(dev.StateMachine.States) == 0 {
+				return fmt.Errorf("device %s: state machine needs at least one state", dev.ID)
+			}
+		case "":
+			return fmt.Errorf("device %s: type cannot be empty", dev.ID)
+		default:
+			return fmt.Errorf("device %s: unknown type %s", dev.ID, dev.Type)
+		}
+	}
+
+	for i := range c.Dependencies {
+		dep := &c.Dependencies[i]
+		if dep.Device == "" || dep.Upstream == "" {
+			return fmt.Errorf("dependency needs a device and an upstream device")
+		}
+		switch dep.Effect {
+		case "":
+			dep.Effect = "mute"
+		case "mute", "fail", "degrade":
+		default:
+			return fmt.Errorf("dependency of %s: unknown effect %s", dep.Device, dep.Effect)
+		}
+	}
+
+	for i := range c.Redundancy {
+		g := &c.Redundancy[i]
+		if g.ID == "" {
+			return fmt.Errorf("redundancy group ID cannot be empty")
+		}
+		if devices[g.ID] {
+			return fmt.Errorf("redundancy group %s: ID is already used by a device", g.ID)
+		}
+		if g.Active == 0 {
+			g.Active = 1
+		}
+		if g.Active < 0 || g.Active >= len(g.Units) {
+			return fmt.Errorf("redundancy group %s: needs more units than the %d active", g.ID, g.Active)
+		}
+	}
+
+	for _, r := range c.FDIR {
+		if r.ID == "" || r.Topic == "" || r.Monitor == "" {
+			return fmt.Errorf("fdir rule needs an ID, a topic and a monitor")
+		}
+		if r.Persistence < 0 {
+			return fmt.Errorf("fdir rule %s: persistence cannot be negative", r.ID)
+		}
+		for _, a := range r.Isolation {
+			if a.After != 0 {
+				return fmt.Errorf("fdir rule %s: isolation actions are issued at once and take no after", r.ID)
+			}
+		}
+	}
+
+	if b := c.Bus; b != nil {
+		switch b.Delivery {
+		case "":
+			b.Delivery = "sync"
+		case "sync", "async":
+		default:
+			return fmt.Errorf("bus: unknown delivery %s", b.Delivery)
+		}
+		if b.QueueSize < 0 {
+			return fmt.Errorf("bus: queue size cannot be negative")
+		}
+		if b.QueueSize == 0 {
+			b.QueueSize = 256
+		}
+		switch b.Overflow {
+		case "":
+			b.Overflow = "drop_oldest"
+		case "block", "drop_oldest", "drop_newest":
+		default:
+			return fmt.Errorf("bus: unknown overflow policy %s", b.Overflow)
+		}
+	}
+
+	// Devices behind an avionics bus terminal or a CAN node
+	attached := make(map[string]bool)
+	if a := c.AvionicsBus; a != nil {
+		if a.ID == "" {
+			a.ID = "avionics_bus"
+		}
+		if devices[a.ID] {
+			return fmt.Errorf("avionics bus %s: ID is already used by a device", a.ID)
+		}
+		terminals := make(map[int]bool)
+		for _, t := range a.Terminals {
+			if terminals[t.Address] {
+				return fmt.Errorf("avionics bus %s: duplicate terminal %d", a.ID, t.Address)
+			}
+			terminals[t.Address] = true
+			for _, id := range t.Devices {
+				if attached[id] {
+					return fmt.Errorf("avionics bus %s: device %s is behind two terminals", a.ID, id)
+				}
+				attached[id] = true
+			}
+		}
+		for _, t := range a.Schedule {
+			if !terminals[t.Terminal] {
+				return fmt.Errorf("avionics bus %s: transfer of %s to unknown terminal %d", a.ID, t.Topic, t.Terminal)
+			}
+		}
+	}
+
+	segments := make(map[string]bool)
+	for _, b := range c.CANBuses {
+		if b.ID == "" {
+			return fmt.Errorf("CAN bus needs an ID")
+		}
+		if devices[b.ID] || segments[b.ID] || c.AvionicsBus != nil && c.AvionicsBus.ID == b.ID {
+			return fmt.Errorf("CAN bus %s: ID is already used", b.ID)
+		}
+		segments[b.ID] = true
+		for _, n := range b.Nodes {
+			for _, id := range n.Devices {
+				if attached[id] {
+					return fmt.Errorf("CAN bus %s: device %s is already attached to a bus", b.ID, id)
+				}
+				attached[id] = true
+			}
+		}
+	}
+
+	if m := c.Modes; m != nil {
+		if len(m.Modes) == 0 {
+			return fmt.Errorf("modes: at least one mode is required")
+		}
+		if devices["mode"] {
+			return fmt.Errorf("modes: device ID mode is reserved for the mode manager")
+		}
+		for _, mode := range m.Modes {
+			if mode.Name == "" {
+				return fmt.Errorf("modes: mode name cannot be empty")
+			}
+		}
+	}
+
+	for _, f := range c.Faults {
+		if f.Device == "" || f.Type == "" {
+			return fmt.Errorf("fault needs a device and a type")
+		}
+		if f.At < 0 || f.For < 0 {
+			return fmt.Errorf("fault on %s: times cannot be negative", f.Device)
+		}
+	}
+
+	if c.DefaultStation != "" && !stations[c.DefaultStation] {
+		return fmt.Errorf("default station %s is not defined", c.DefaultStation)
+	}
+	return nil
+}
+
+// validate checks the health thresholds
+func (c *HealthConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if (c.DegradedAfter != nil && *c.DegradedAfter < 0) || (c.FailedAfter != nil && *c.FailedAfter < 0) {
+		return fmt.Errorf("thresholds cannot be negative")
+	}
+	return nil
+}
+
+// validate checks the sensor truth source and error models
+func (c *SensorConfig) validate() error {
+	if c.Truth != nil && (c.Truth.Topic == "" || c.Truth.ID == "") {
+		return fmt.Errorf("truth source needs a topic and an ID")
+	}
+	if p := c.Publish; p != nil {
+		switch p.Policy {
+		case "on_change", "on_request":
+		case "periodic":
+			if p.Period <= 0 {
+				return fmt.Errorf("periodic publish policy needs a positive period")
+			}
+		default:
+			return fmt.Errorf("unknown publish policy %s", p.Policy)
+		}
+	}
+	for _, m := range c.Errors {
+		switch m.Type {
+		case "gaussian", "bias", "drift", "quantize":
+		case "scale":
+			if m.Factor == 0 {
+				return fmt.Errorf("scale needs a non-zero factor")
+			}
+		case "saturate":
+			if m.Min > m.Max {
+				return fmt.Errorf("saturate min is greater than max")
+			}
+		case "latency", "sample_hold":
+			if m.Delay < 0 || m.Period < 0 {
+				return fmt.Errorf("%s duration cannot be negative", m.Type)
+			}
+		default:
+			return fmt.Errorf("unknown error model %s", m.Type)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package device
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	speedOfLight     = 299792458.0 // m/s
+	boltzmannDB      = -228.6      // 10*log10(k), dBW/(K*Hz)
+	minLinkDataRate  = 1000.0      // bps, below this the receiver cannot lock
+	defaultFrequency = 2.2e9       // Hz, S-band
+)
+
+// Comms models a space-ground radio link and its link budget
+// It publishes on the "comms" topic: margin (dB), lock (bool),
+// status (locked, degraded, unlocked), bandwidth (bps) and range (km)
+type Comms struct {
+	*BaseDevice
+	mu           sync.Mutex
+	rangeKm      float64
+	txPower      float64 // dBW
+	txGain       float64 // dBi
+	rxGain       float64 // dBi
+	frequency    float64 // Hz
+	dataRate     float64 // bps, commanded
+	systemTemp   float64 // K
+	losses       float64 // dB, pointing, atmospheric and implementation losses
+	requiredEbN0 float64 // dB
+	enabled      bool
+}
+
+// NewComms creates a comms device with a LEO S-band link
+func NewComms(id string) *Comms {
+	return &Comms{
+		BaseDevice:   NewBaseDevice(id, time.Second),
+		rangeKm:      2000,
+		txPower:      0,
+		txGain:       3,
+		rxGain:       30,
+		frequency:    defaultFrequency,
+		dataRate:     64000,
+		systemTemp:   200,
+		losses:       3,
+		requiredEbN0: 9.6,
+		enabled:      true,
+	}
+}
+
+// HandleInput processes comms commands
+// Commands: [on/off], [parameter, value] where parameter is one of
+// range, tx_power, tx_gain, rx_gain, data_rate, losses
+func (c *Comms) HandleInput(msg Message) error {
+	if msg.ID != c.id {
+		return nil
+	}
+	if len(msg.Values) == 0 {
+		return fmt.Errorf("comms %s: missing command", c.id)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if on, err := ToBool(msg.Values[0]); err == nil {
+		c.enabled = on
+		return nil
+	}
+	if len(msg.Values) < 2 {
+		return fmt.Errorf("comms %s: missing value", c.id)
+	}
+	val, err := ToFloat(msg.Values[1])
+	if err != nil {
+		return fmt.Errorf("comms %s: %w", c.id, err)
+	}
+	switch param := strings.ToLower(toString(msg.Values[0])); param {
+	case "range":
+		if val <= 0 {
+			return fmt.Errorf("comms %s: range must be positive", c.id)
+		}
+		c.rangeKm = val
+	case "tx_power":
+		c.txPower = val
+	case "tx_gain":
+		c.txGain = val
+	case "rx_gain":
+		c.rxGain = val
+	case "data_rate":
+		if val <= 0 {
+			return fmt.Errorf("comms %s: data rate must be positive", c.id)
+		}
+		c.dataRate = val
+	case "losses":
+		c.losses = val
+	default:
+		return fmt.Errorf("comms %s: unknown parameter %s", c.id, param)
+	}
+	return nil
+}
+
+// linkBudget returns the margin at the commanded data rate and the highest
+// data rate the link can currently close
+func (c *Comms) linkBudget() (margin, maxRate float64) {
+	wavelength := speedOfLight / c.frequency
+	pathLoss := 20 * math.Log10(4*math.Pi*c.rangeKm*1000/wavelength)
+	eirp := c.txPower + c.txGain
+	cn0 := eirp - pathLoss + c.rxGain - c.losses - boltzmannDB - 10*math.Log10(c.systemTemp)
+	margin = cn0 - 10*math.Log10(c.dataRate) - c.requiredEbN0
+	maxRate = math.Pow(10, (cn0-c.requiredEbN0)/10)
+	return margin, maxRate
+}
+
+// Health reports the radio off when switched off
+// Loss of lock is a matter of geometry rather than a fault
+func (c *Comms) Health() Health {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.enabled {
+		return Health{Status: HealthOff}
+	}
+	return Health{Status: HealthNominal}
+}
+
+// Tick recomputes the link budget and publishes the link state
+func (c *Comms) Tick() error {
+	c.mu.Lock()
+	margin, maxRate := c.linkBudget()
+	rangeKm := c.rangeKm
+
+	status := "locked"
+	bandwidth := c.dataRate
+	switch {
+	case !c.enabled || maxRate < minLinkDataRate:
+		status = "unlocked"
+		bandwidth = 0
+	case maxRate < c.dataRate:
+		// The transmitter falls back to the highest rate the link supports
+		status = "degraded"
+		bandwidth = maxRate
+	}
+	c.mu.Unlock()
+
+	params := []struct {
+		name  string
+		value interface{}
+	}{
+		{"margin", margin},
+		{"lock", status != "unlocked"},
+		{"status", status},
+		{"bandwidth", bandwidth},
+		{"range", rangeKm},
+	}
+	for _, p := range params {
+		if err := publishTelemetry(c.bus, "comms", c.id, c.Now(), p.name, p.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package device
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Condition compares a bus parameter with a constant, e.g. "cabin.ppo2 < 19.5"
+// Numbers support <, <=, >, >=, == and !=; strings and booleans support
+// == and !=
+type Condition struct {
+	Param string
+	Op    string
+	Value interface{} // float64, bool or string
+}
+
+// ParseCondition parses a "<parameter> <operator> <value>" expression
+func ParseCondition(expr string) (Condition, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != 3 {
+		return Condition{}, fmt.Errorf("invalid condition %q: expected <parameter> <operator> <value>", expr)
+	}
+
+	c := Condition{Param: parts[0], Op: parts[1]}
+	switch c.Op {
+	case "<", "<=", ">", ">=", "==", "!=":
+	default:
+		return Condition{}, fmt.Errorf("invalid condition %q: unknown operator %s", expr, c.Op)
+	}
+
+	raw := parts[2]
+	if f, err := strconv.ParseFloat(raw, 64); err == nil {
+		c.Value = f
+	} else if b, err := strconv.ParseBool(raw); err == nil {
+		c.Value = b
+	} else {
+		c.Value = strings.Trim(raw, `"'`)
+	}
+	if _, numeric := c.Value.(float64); !numeric && c.Op != "==" && c.Op != "!=" {
+		return Condition{}, fmt.Errorf("invalid condition %q: %s needs a number", expr, c.Op)
+	}
+	return c, nil
+}
+
+// String returns the condition as an expression
+func (c Condition) String() string {
+	return fmt.Sprintf("%s %s %v", c.Param, c.Op, c.Value)
+}
+
+// Eval reports whether value satisfies the condition
+func (c Condition) Eval(value interface{}) bool {
+	switch want := c.Value.(type) {
+	case float64:
+		got, err := ToFloat(value)
+		if err != nil {
+			var ok bool
+			if got, ok = numeric(value); !ok {
+				return false
+			}
+		}
+		switch c.Op {
+		case "<":
+			return got < want
+		case "<=":
+			return got <= want
+		case ">":
+			return got > want
+		case ">=":
+			return got >= want
+		case "==":
+			return got == want
+		default:
+			return got != want
+		}
+	case bool:
+		got, err := ToBool(value)
+		if err != nil {
+			return false
+		}
+		return (got == want) == (c.Op == "==")
+	default:
+		return (toString(value) == toString(want)) == (c.Op == "==")
+	}
+}
+
+// EvalAll reports whether every condition holds for the latest parameter
+// values, returning the first one that does not
+func EvalAll(conds []Condition, values map[string]interface{}) (Condition, bool) {
+	for _, c := range conds {
+		v, known := values[c.Param]
+		if !known || !c.Eval(v) {
+			return c, false
+		}
+	}
+	return Condition{}, true
+}
@@ -11,6 +11,14 @@ type Message struct {
 	Values []interface{}
 	Time   time.Time
 	Source string
+	// Destination addresses a reply to the Source of an earlier message
+	Destination string
+	// CorrelationID matches a reply to its request
+	CorrelationID string
+	// ReplyTo is the topic a request expects its reply on
+	ReplyTo string
+	// Error reports a failed request in its reply
+	Error string
 }
 
 // Device represents a ship module with input/output capabilities
@@ -31,6 +39,12 @@ type Device interface {
 	GetTickRate() time.Duration
 }
 
+// Queryable is implemented by devices that answer client queries
+type Queryable interface {
+	// Query processes a message and returns the values to send back
+	Query(msg Message) ([]interface{}, error)
+}
+
 // Bus defines the interface for inter-device communication
 type Bus interface {
 	// Publish sends a message to all subscribers of a topic
@@ -43,10 +57,64 @@ type Bus interface {
 	Unsubscribe(topic string, device Device) error
 }
 
+// Replier is implemented by devices that answer some requests themselves
+// Requests to other devices are acknowledged by the ship once handled.
+type Replier interface {
+	// RepliesTo reports whether the device sends the reply to a request
+	RepliesTo(msg Message) bool
+}
+
+// Requester is implemented by buses supporting request/reply
+type Requester interface {
+	// Request publishes a request on a topic and waits up to timeout for
+	// the reply
+	Request(topic string, msg Message, timeout time.Duration) (Message, error)
+}
+
+// NoReplyError is returned when no device answers a request in time
+type NoReplyError struct {
+	ID      string // addressed device
+	Timeout time.Duration
+}
+
+// Error describes the unanswered request
+func (e *NoReplyError) Error() string {
+	return fmt.Sprintf("no reply from %s within %v", e.ID, e.Timeout)
+}
+
+// NewReply returns the topic and the message answering msg
+// Requests are answered on their ReplyTo topic, other messages on the
+// "replies" topic addressed to their source. A non-nil err fails the
+// request.
+func NewReply(msg Message, source string, values []interface{}, err error, now time.Time) (string, Message) {
+	reply := Message{
+		ID:            source,
+		Values:        values,
+		Time:          now,
+		Source:        source,
+		Destination:   msg.Source,
+		CorrelationID: msg.CorrelationID,
+	}
+	if err != nil {
+		reply.Error = err.Error()
+	}
+	if msg.ReplyTo != "" {
+		return msg.ReplyTo, reply
+	}
+	return "replies", reply
+}
+
+// Clock provides simulation time to devices
+type Clock interface {
+	// Now returns the current simulation time
+	Now() time.Time
+}
+
 // BaseDevice provides common functionality for devices
 type BaseDevice struct {
 	id       string
 	bus      Bus
+	clock    Clock
 	topics   []string
 	lastTick time.Time
 	tickRate time.Duration
@@ -68,15 +136,61 @@ func (d *BaseDevice) ID() string {
 
 // Subscribe registers the device to receive messages on specific topics
 func (d *BaseDevice) Subscribe(bus Bus) error {
+	return d.SubscribeAs(bus, d)
+}
+
+// SubscribeAs registers dev as the subscriber of the device's topics
+// Devices embedding BaseDevice pass themselves so the bus calls their
+// HandleInput rather than the BaseDevice default
+func (d *BaseDevice) SubscribeAs(bus Bus, dev Device) error {
 	d.bus = bus
 	for _, topic := range d.topics {
-		if err := bus.Subscribe(topic, d); err != nil {
+		if err := bus.Subscribe(topic, dev); err != nil {
 			return fmt.Errorf("failed to subscribe to topic %s: %w", topic, err)
 		}
 	}
 	return nil
 }
 
+// SetClock sets the simulation clock used by the device
+func (d *BaseDevice) SetClock(clock Clock) {
+	d.clock = clock
+}
+
+// Now returns the simulation time, or the wall clock time when the device
+// is not attached to a simulation clock
+func (d *BaseDevice) Now() time.Time {
+	if d.clock == nil {
+		return time.Now()
+	}
+	return d.clock.Now()
+}
+
+// Reply sends values back to the sender of msg
+// Requests are answered on their reply topic, replies to network clients
+// are delivered to their connection
+func (d *BaseDevice) Reply(msg Message, values []interface{}) error {
+	topic, reply := NewReply(msg, d.id, values, nil, d.Now())
+	if err := d.bus.Publish(topic, reply); err != nil {
+		return fmt.Errorf("failed to publish reply: %w", err)
+	}
+	return nil
+}
+
+// Request sends a request on a topic, usually "commands" with the ID of
+// the addressed device, and returns the reply
+// The caller is blocked until the reply arrives or the timeout expires
+// with a NoReplyError.
+func (d *BaseDevice) Request(topic string, msg Message, timeout time.Duration) (Message, error) {
+	r, ok := d.bus.(Requester)
+	if !ok {
+		return Message{}, fmt.Errorf("device %s: bus does not support requests", d.id)
+	}
+	msg.Source = d.id
+	msg.Time = d.Now()
+	return r.Request(topic, msg, timeout)
+}
+
 // AddTopic adds a topic to the device's subscription list
 func (d *BaseDevice) AddTopic(topic string) {
 	d.topics = append(d.topics, topic)
@@ -92,6 +206,12 @@ func (d *BaseDevice) Tick() error {
 	return nil
 }
 
+// SetTickRate sets the device's tick rate
+// It must be called before the device is registered
+func (d *BaseDevice) SetTickRate(rate time.Duration) {
+	d.tickRate = rate
+}
+
 // GetTickRate returns the device's tick rate
 func (d *BaseDevice) GetTickRate() time.Duration {
 	return d.tickRate
@@ -1,12 +1,49 @@
 package device
 
 import (
+	"fmt"
 	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
 )
 
-// Echo represents a device that prints received messages
+// Echo transforms applied to the values sent back
+const (
+	TransformNone      = ""
+	TransformUppercase = "uppercase"
+	TransformLowercase = "lowercase"
+	TransformReverse   = "reverse" // reverse the order of the values
+	TransformNegate    = "negate"  // negate numeric values
+	TransformScale     = "scale"   // multiply numeric values by the scale factor
+
+	// echoTickRate is how often delayed replies are released
+	echoTickRate = 10 * time.Millisecond
+)
+
+// EchoConfig holds the behaviour of an echo device
+// The zero value replies immediately with the values unchanged
+type EchoConfig struct {
+	Delay           time.Duration // simulation time before replying
+	Transform       string
+	Scale           float64 // factor for the scale transform
+	LossProbability float64 // chance of dropping a reply, 0 to 1
+}
+
+// pendingReply is a reply waiting for its delay to elapse
+type pendingReply struct {
+	due    time.Time
+	msg    Message
+	values []interface{}
+}
+
+// Echo represents a device that sends received messages back to their sender
 type Echo struct {
 	*BaseDevice
+	mu      sync.Mutex
+	cfg     EchoConfig
+	pending []pendingReply
 }
 
 // NewEcho creates a new echo device
@@ -17,14 +54,110 @@ func NewEcho(id string) *Echo {
 	return e
 }
 
-// HandleInput processes incoming messages and prints them
+// Configure sets the delay, transform and loss of the echo device
+// It must be called before the device is registered
+func (e *Echo) Configure(cfg EchoConfig) error {
+	switch cfg.Transform {
+	case TransformNone, TransformUppercase, TransformLowercase, TransformReverse, TransformNegate, TransformScale:
+	default:
+		return fmt.Errorf("echo %s: unknown transform %s", e.id, cfg.Transform)
+	}
+	if cfg.LossProbability < 0 || cfg.LossProbability > 1 {
+		return fmt.Errorf("echo %s: loss probability must be between 0 and 1", e.id)
+	}
+	if cfg.Delay < 0 {
+		return fmt.Errorf("echo %s: delay cannot be negative", e.id)
+	}
+
+	e.cfg = cfg
+	if cfg.Delay > 0 {
+		// Delayed replies are released on tick
+		e.tickRate = echoTickRate
+	}
+	return nil
+}
+
+// RepliesTo reports that the echo answers every request itself, lost
+// replies included
+func (e *Echo) RepliesTo(msg Message) bool {
+	return true
+}
+
+// HandleInput sends the received message back to its source
 func (e *Echo) HandleInput(msg Message) error {
 	log.Printf("Echo %s received: ID=%s, Values=%v, Source=%s",
 		e.id, msg.ID, msg.Values, msg.Source)
+
+	if e.cfg.LossProbability > 0 && rand.Float64() < e.cfg.LossProbability {
+		log.Printf("Echo %s dropped reply to %s", e.id, msg.Source)
+		return nil
+	}
+
+	values := e.transform(msg.Values)
+	if e.cfg.Delay == 0 {
+		return e.Reply(msg, values)
+	}
+
+	e.mu.Lock()
+	e.pending = append(e.pending, pendingReply{due: e.Now().Add(e.cfg.Delay), msg: msg, values: values})
+	e.mu.Unlock()
 	return nil
 }
 
-// Tick is not needed for echo device
+// transform applies the configured transform to a copy of the values
+func (e *Echo) transform(values []interface{}) []interface{} {
+	out := make([]interface{}, len(values))
+	copy(out, values)
+
+	for i, v := range out {
+		switch e.cfg.Transform {
+		case TransformUppercase:
+			if s, ok := v.(string); ok {
+				out[i] = strings.ToUpper(s)
+			}
+		case TransformLowercase:
+			if s, ok := v.(string); ok {
+				out[i] = strings.ToLower(s)
+			}
+		case TransformNegate:
+			if f, ok := v.(float64); ok {
+				out[i] = -f
+			}
+		case TransformScale:
+			if f, ok := v.(float64); ok {
+				out[i] = f * e.cfg.Scale
+			}
+		}
+	}
+	if e.cfg.Transform == TransformReverse {
+		for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+			out[i], out[j] = out[j], out[i]
+		}
+	}
+	return out
+}
+
+// Tick sends the delayed replies that are due
 func (e *Echo) Tick() error {
+	now := e.Now()
+
+	e.mu.Lock()
+	var due []pendingReply
+	remaining := e.pending[:0]
+	for _, p := range e.pending {
+		if !p.due.After(now) {
+			due = append(due, p)
+		} else {
+			remaining = append(remaining, p)
+		}
+	}
+	e.pending = remaining
+	e.mu.Unlock()
+
+	for _, p := range due {
+		if err := e.Reply(p.msg, p.values); err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -0,0 +1,131 @@
+package device
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// ErrorModel turns a true value into a measured value
+// Models are applied in order, each receiving the previous model's output.
+// t is the simulation time of the sample.
+type ErrorModel interface {
+	Apply(value float64, t time.Time) float64
+}
+
+// GaussianNoise adds zero-mean white noise
+type GaussianNoise struct {
+	Sigma float64
+}
+
+// Apply adds a normally distributed error
+func (m *GaussianNoise) Apply(value float64, t time.Time) float64 {
+	return value + rand.NormFloat64()*m.Sigma
+}
+
+// Bias adds a constant offset
+type Bias struct {
+	Offset float64
+}
+
+// Apply adds the offset
+func (m *Bias) Apply(value float64, t time.Time) float64 {
+	return value + m.Offset
+}
+
+// BiasDrift adds a bias that grows linearly at Rate per second and wanders
+// as a random walk with RandomWalk standard deviation per sqrt(second)
+type BiasDrift struct {
+	Rate       float64
+	RandomWalk float64
+	bias       float64
+	last       time.Time
+}
+
+// Apply advances the drifting bias and adds it
+func (m *BiasDrift) Apply(value float64, t time.Time) float64 {
+	if !m.last.IsZero() {
+		dt := t.Sub(m.last).Seconds()
+		m.bias += m.Rate * dt
+		if m.RandomWalk > 0 && dt > 0 {
+			m.bias += rand.NormFloat64() * m.RandomWalk * math.Sqrt(dt)
+		}
+	}
+	m.last = t
+	return value + m.bias
+}
+
+// ScaleFactor multiplies the value
+type ScaleFactor struct {
+	Factor float64
+}
+
+// Apply scales the value
+func (m *ScaleFactor) Apply(value float64, t time.Time) float64 {
+	return value * m.Factor
+}
+
+// Quantization rounds the value to the ADC resolution Step
+type Quantization struct {
+	Step float64
+}
+
+// Apply rounds the value to the nearest step
+func (m *Quantization) Apply(value float64, t time.Time) float64 {
+	if m.Step <= 0 {
+		return value
+	}
+	return math.Round(value/m.Step) * m.Step
+}
+
+// Saturation clips the value to the sensor range
+type Saturation struct {
+	Min, Max float64
+}
+
+// Apply clips the value
+func (m *Saturation) Apply(value float64, t time.Time) float64 {
+	return math.Max(m.Min, math.Min(value, m.Max))
+}
+
+// sample is a value recorded at a simulation time
+type sample struct {
+	t     time.Time
+	value float64
+}
+
+// Latency delays the output by Delay
+type Latency struct {
+	Delay   time.Duration
+	history []sample
+}
+
+// Apply records the value and returns the one from Delay ago
+// Until enough history exists the oldest recorded value is returned
+func (m *Latency) Apply(value float64, t time.Time) float64 {
+	m.history = append(m.history, sample{t: t, value: value})
+
+	cutoff := t.Add(-m.Delay)
+	n := 0
+	for n+1 < len(m.history) && !m.history[n+1].t.After(cutoff) {
+		n++
+	}
+	m.history = m.history[n:]
+	return m.history[0].value
+}
+
+// SampleHold samples the value every Period and holds it in between
+type SampleHold struct {
+	Period  time.Duration
+	held    float64
+	sampled time.Time
+}
+
+// Apply returns the held value, sampling a new one when the period elapses
+func (m *SampleHold) Apply(value float64, t time.Time) float64 {
+	if m.sampled.IsZero() || t.Sub(m.sampled) >= m.Period {
+		m.held = value
+		m.sampled = t
+	}
+	return m.held
+}
@@ -0,0 +1,45 @@
+package device
+
+import "time"
+
+// HealthStatus is the health state of a device
+type HealthStatus string
+
+const (
+	HealthNominal  HealthStatus = "nominal"
+	HealthDegraded HealthStatus = "degraded"
+	HealthFailed   HealthStatus = "failed"
+	HealthOff      HealthStatus = "off"
+)
+
+// Severity orders statuses from best to worst
+// A device that is off is not faulty, it ranks with nominal
+func (s HealthStatus) Severity() int {
+	switch s {
+	case HealthDegraded:
+		return 1
+	case HealthFailed:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// Health describes the health of a device
+// Code is a short machine readable fault code, Reason a human readable
+// explanation. Both are empty for a nominal device. Cause names the root
+// cause device when the fault propagated from a device upstream.
+type Health struct {
+	Status HealthStatus `json:"status"`
+	Code   string       `json:"code,omitempty"`
+	Reason string       `json:"reason,omitempty"`
+	Cause  string       `json:"cause,omitempty"`
+	Since  time.Time    `json:"since"` // simulation time of the last change
+}
+
+// HealthReporter is implemented by devices that report their own health,
+// e.g. when switched off
+type HealthReporter interface {
+	// Health returns the current health of the device
+	Health() Health
+}
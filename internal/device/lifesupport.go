@@ -0,0 +1,518 @@
+package device
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Life support devices exchange gas flows on the "cabin_flows" topic and the
+// cabin publishes its atmosphere state on the "life_support" topic.
+// Flow messages carry three values in mol/s: O2, CO2 and H2O added to the
+// cabin (negative values remove gas). Pressures are published in kPa.
+
+const (
+	gasConstant = 8.314 // J/(mol*K)
+
+	// Per crew member metabolic rates at rest, mol/s
+	crewO2Consumption  = 3.04e-4
+	crewCO2Production  = 2.63e-4
+	crewH2OProduction  = 1.16e-3
+	defaultCabinTemp   = 295.15 // K
+	defaultCabinVolume = 100.0  // m^3
+
+	// breachArea is the leak area applied by the "breach" command, m^2
+	breachArea = 0.01
+
+	// minScrubberEfficiency is the efficiency below which a scrubber
+	// reports itself degraded
+	minScrubberEfficiency = 0.5
+
+	// flowPeriods is how many publish periods of its source a flow is
+	// applied without an update, sources silenced by a failure, a power
+	// loss or a mode stop contributing after that
+	flowPeriods = 2
+)
+
+// gasFlow represents gas exchange with the cabin in mol/s
+type gasFlow struct {
+	o2, co2, h2o float64
+}
+
+// sourceFlow is the last flow received from a source
+type sourceFlow struct {
+	gasFlow
+	at     time.Time     // simulation time received
+	period time.Duration // between the last two flows of the source
+}
+
+// publishFlow sends a gas flow message on the cabin flow topic, stamped
+// with the simulation time of the source
+func publishFlow(bus Bus, id string, now time.Time, flow gasFlow) error {
+	msg := Message{
+		ID:     id + ".flow",
+		Values: []interface{}{flow.o2, flow.co2, flow.h2o},
+		Time:   now,
+		Source: id,
+	}
+	if err := bus.Publish("cabin_flows", msg); err != nil {
+		return fmt.Errorf("failed to publish flow: %w", err)
+	}
+	return nil
+}
+
+// publishTelemetry sends a single named parameter on a topic
+// The message ID is "<device id>.<parameter>", the time the simulation
+// time of the device
+func publishTelemetry(bus Bus, topic, id string, now time.Time, param string, value interface{}) error {
+	msg := Message{
+		ID:     id + "." + param,
+		Values: []interface{}{value},
+		Time:   now,
+		Source: id,
+	}
+	if err := bus.Publish(topic, msg); err != nil {
+		return fmt.Errorf("failed to publish %s: %w", param, err)
+	}
+	return nil
+}
+
+// saturationPressure returns the water vapour saturation pressure in Pa
+// for a temperature in kelvin (Tetens equation)
+func saturationPressure(temp float64) float64 {
+	c := temp - 273.15
+	return 610.78 * math.Exp(17.27*c/(c+237.3))
+}
+
+// Cabin models the pressurised cabin atmosphere
+type Cabin struct {
+	*BaseDevice
+	mu       sync.Mutex
+	volume   float64               // m^3
+	temp     float64               // K
+	o2       float64               // mol
+	co2      float64               // mol
+	n2       float64               // mol
+	h2o      float64               // mol
+	leakArea float64               // m^2
+	flows    map[string]sourceFlow // by source
+	lastTime time.Time             // simulation time of the last tick
+}
+
+// NewCabin creates a cabin with a sea-level atmosphere
+func NewCabin(id string) *Cabin {
+	c := &Cabin{
+		BaseDevice: NewBaseDevice(id, time.Second),
+		volume:     defaultCabinVolume,
+		temp:       defaultCabinTemp,
+		flows:      make(map[string]sourceFlow),
+	}
+	c.o2 = c.moles(21300)
+	c.co2 = c.moles(400)
+	c.n2 = c.moles(79600)
+	c.h2o = c.moles(1200)
+	c.AddTopic("cabin_flows")
+	return c
+}
+
+// moles converts a partial pressure in Pa to moles in the cabin volume
+func (c *Cabin) moles(pressure float64) float64 {
+	return pressure * c.volume / (gasConstant * c.temp)
+}
+
+// pressure converts moles in the cabin volume to a partial pressure in Pa
+func (c *Cabin) pressure(moles float64) float64 {
+	return moles * gasConstant * c.temp / c.volume
+}
+
+// Subscribe registers the cabin to receive gas flows
+func (c *Cabin) Subscribe(bus Bus) error {
+	return c.SubscribeAs(bus, c)
+}
+
+// HandleInput records gas flows and processes cabin commands
+// Commands: ["leak", area_m2], ["breach"], ["seal"]
+func (c *Cabin) HandleInput(msg Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if msg.ID != c.id {
+		if len(msg.Values) != 3 {
+			return fmt.Errorf("invalid flow from %s: expected 3 values", msg.Source)
+		}
+		var f [3]float64
+		for i, v := range msg.Values {
+			val, err := ToFloat(v)
+			if err != nil {
+				return fmt.Errorf("invalid flow from %s: %w", msg.Source, err)
+			}
+			f[i] = val
+		}
+		now := c.Now()
+		period := c.tickRate
+		if prev, exists := c.flows[msg.Source]; exists {
+			period = prev.period
+			if gap := now.Sub(prev.at); gap > 0 {
+				period = gap
+			}
+		}
+		c.flows[msg.Source] = sourceFlow{gasFlow: gasFlow{o2: f[0], co2: f[1], h2o: f[2]}, at: now, period: period}
+		return nil
+	}
+
+	if len(msg.Values) == 0 {
+		return fmt.Errorf("cabin %s: missing command", c.id)
+	}
+	switch cmd := strings.ToLower(toString(msg.Values[0])); cmd {
+	case "leak":
+		if len(msg.Values) < 2 {
+			return fmt.Errorf("cabin %s: leak requires an area", c.id)
+		}
+		area, err := ToFloat(msg.Values[1])
+		if err != nil {
+			return fmt.Errorf("cabin %s: %w", c.id, err)
+		}
+		if area < 0 {
+			return fmt.Errorf("cabin %s: leak area cannot be negative", c.id)
+		}
+		c.leakArea = area
+	case "breach":
+		c.leakArea = breachArea
+	case "seal":
+		c.leakArea = 0
+	default:
+		return fmt.Errorf("cabin %s: unknown command %s", c.id, cmd)
+	}
+	return nil
+}
+
+// Tick integrates gas flows and leakage over the simulation time since the
+// last tick, then publishes the atmosphere state
+// Flows not updated for flowPeriods publish periods of their source are
+// dropped.
+func (c *Cabin) Tick() error {
+	c.mu.Lock()
+	now := c.Now()
+	dt := c.tickRate.Seconds()
+	if !c.lastTime.IsZero() {
+		dt = math.Max(0, now.Sub(c.lastTime).Seconds())
+	}
+	c.lastTime = now
+
+	var total gasFlow
+	for source, f := range c.flows {
+		if now.Sub(f.at) > flowPeriods*f.period {
+			delete(c.flows, source)
+			continue
+		}
+		total.o2 += f.o2
+		total.co2 += f.co2
+		total.h2o += f.h2o
+	}
+	c.o2 = math.Max(0, c.o2+total.o2*dt)
+	c.co2 = math.Max(0, c.co2+total.co2*dt)
+	c.h2o = math.Max(0, c.h2o+total.h2o*dt)
+
+	// Excess water vapour condenses out
+	if maxH2O := c.moles(saturationPressure(c.temp)); c.h2o > maxH2O {
+		c.h2o = maxH2O
+	}
+
+	// Choked flow through the leak removes every gas proportionally
+	if c.leakArea > 0 {
+		const dischargeCoeff, gamma, airMolarMass = 0.6, 1.4, 0.029
+		speed := math.Sqrt(gamma * gasConstant * c.temp / airMolarMass)
+		choke := math.Pow(2/(gamma+1), (gamma+1)/(2*(gamma-1)))
+		remain := math.Exp(-dischargeCoeff * c.leakArea * speed * choke / c.volume * dt)
+		c.o2 *= remain
+		c.co2 *= remain
+		c.n2 *= remain
+		c.h2o *= remain
+	}
+
+	ppO2 := c.pressure(c.o2) / 1000
+	ppCO2 := c.pressure(c.co2) / 1000
+	pressure := c.pressure(c.o2+c.co2+c.n2+c.h2o) / 1000
+	humidity := 100 * c.pressure(c.h2o) / saturationPressure(c.temp)
+	leaking := c.leakArea > 0
+	temp := c.temp
+	c.mu.Unlock()
+
+	params := []struct {
+		name  string
+		value interface{}
+	}{
+		{"ppo2", ppO2},
+		{"ppco2", ppCO2},
+		{"pressure", pressure},
+		{"humidity", humidity},
+		{"leak", leaking},
+		{"temperature", temp},
+	}
+	for _, p := range params {
+		if err := publishTelemetry(c.bus, "life_support", c.id, c.Now(), p.name, p.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Crew models the metabolic load of the crew on the cabin atmosphere
+type Crew struct {
+	*BaseDevice
+	mu       sync.Mutex
+	count    int
+	activity float64 // metabolic multiplier, 1 at rest
+}
+
+// NewCrew creates a crew with the given number of members
+func NewCrew(id string, count int) *Crew {
+	return &Crew{
+		BaseDevice: NewBaseDevice(id, time.Second),
+		count:      count,
+		activity:   1,
+	}
+}
+
+// HandleInput processes crew commands
+// Commands: [count], ["count", n], ["activity", multiplier]
+func (c *Crew) HandleInput(msg Message) error {
+	if len(msg.Values) == 0 {
+		return fmt.Errorf("crew %s: missing command", c.id)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if n, err := ToFloat(msg.Values[0]); err == nil {
+		return c.setCount(n)
+	}
+	if len(msg.Values) < 2 {
+		return fmt.Errorf("crew %s: missing value", c.id)
+	}
+	val, err := ToFloat(msg.Values[1])
+	if err != nil {
+		return fmt.Errorf("crew %s: %w", c.id, err)
+	}
+	switch cmd := strings.ToLower(toString(msg.Values[0])); cmd {
+	case "count":
+		return c.setCount(val)
+	case "activity":
+		if val < 0 {
+			return fmt.Errorf("crew %s: activity cannot be negative", c.id)
+		}
+		c.activity = val
+	default:
+		return fmt.Errorf("crew %s: unknown command %s", c.id, cmd)
+	}
+	return nil
+}
+
+// setCount validates and sets the crew count
+func (c *Crew) setCount(n float64) error {
+	if n < 0 || n != math.Trunc(n) {
+		return fmt.Errorf("crew %s: invalid crew count %v", c.id, n)
+	}
+	c.count = int(n)
+	return nil
+}
+
+// Tick publishes the crew's metabolic gas exchange
+func (c *Crew) Tick() error {
+	c.mu.Lock()
+	load := float64(c.count) * c.activity
+	c.mu.Unlock()
+
+	return publishFlow(c.bus, c.id, c.Now(), gasFlow{
+		o2:  -crewO2Consumption * load,
+		co2: crewCO2Production * load,
+		h2o: crewH2OProduction * load,
+	})
+}
+
+// Scrubber removes CO2 from the cabin atmosphere
+type Scrubber struct {
+	*BaseDevice
+	mu         sync.Mutex
+	cabinID    string
+	on         bool
+	airflow    float64 // m^3/s
+	efficiency float64 // fraction of CO2 removed from the processed air
+	ppCO2      float64 // last known cabin CO2 partial pressure, kPa
+	temp       float64 // last known cabin temperature, K
+}
+
+// NewScrubber creates a CO2 scrubber serving the given cabin
+func NewScrubber(id, cabinID string) *Scrubber {
+	s := &Scrubber{
+		BaseDevice: NewBaseDevice(id, time.Second),
+		cabinID:    cabinID,
+		on:         true,
+		airflow:    0.05,
+		efficiency: 0.9,
+		temp:       defaultCabinTemp,
+	}
+	s.AddTopic("life_support")
+	return s
+}
+
+// Subscribe registers the scrubber to receive cabin telemetry
+func (s *Scrubber) Subscribe(bus Bus) error {
+	return s.SubscribeAs(bus, s)
+}
+
+// HandleInput tracks cabin CO2 and temperature and processes scrubber
+// commands
+// Commands: [on/off], ["efficiency", fraction]
+func (s *Scrubber) HandleInput(msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch msg.ID {
+	case s.cabinID + ".ppco2":
+		if len(msg.Values) > 0 {
+			if v, err := ToFloat(msg.Values[0]); err == nil {
+				s.ppCO2 = v
+			}
+		}
+		return nil
+	case s.cabinID + ".temperature":
+		if len(msg.Values) > 0 {
+			if v, err := ToFloat(msg.Values[0]); err == nil && v > 0 {
+				s.temp = v
+			}
+		}
+		return nil
+	}
+	if msg.ID != s.id {
+		return nil
+	}
+
+	if len(msg.Values) == 0 {
+		return fmt.Errorf("scrubber %s: missing command", s.id)
+	}
+	if on, err := ToBool(msg.Values[0]); err == nil {
+		s.on = on
+		return nil
+	}
+	if strings.ToLower(toString(msg.Values[0])) != "efficiency" || len(msg.Values) < 2 {
+		return fmt.Errorf("scrubber %s: unknown command %v", s.id, msg.Values[0])
+	}
+	eff, err := ToFloat(msg.Values[1])
+	if err != nil {
+		return fmt.Errorf("scrubber %s: %w", s.id, err)
+	}
+	if eff < 0 || eff > 1 {
+		return fmt.Errorf("scrubber %s: efficiency must be between 0 and 1", s.id)
+	}
+	s.efficiency = eff
+	return nil
+}
+
+// Health reports the scrubber off when switched off and degraded when its
+// efficiency is low
+func (s *Scrubber) Health() Health {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case !s.on:
+		return Health{Status: HealthOff}
+	case s.efficiency < minScrubberEfficiency:
+		return Health{
+			Status: HealthDegraded,
+			Code:   "low_efficiency",
+			Reason: fmt.Sprintf("CO2 removal efficiency %.2f", s.efficiency),
+		}
+	}
+	return Health{Status: HealthNominal}
+}
+
+// Tick publishes the CO2 removal rate
+func (s *Scrubber) Tick() error {
+	s.mu.Lock()
+	var removal float64
+	if s.on {
+		concentration := s.ppCO2 * 1000 / (gasConstant * s.temp)
+		removal = s.efficiency * s.airflow * concentration
+	}
+	s.mu.Unlock()
+
+	if err := publishFlow(s.bus, s.id, s.Now(), gasFlow{co2: -removal}); err != nil {
+		return err
+	}
+	return publishTelemetry(s.bus, "life_support", s.id, s.Now(), "removal", removal)
+}
+
+// O2Generator produces oxygen for the cabin
+type O2Generator struct {
+	*BaseDevice
+	mu   sync.Mutex
+	on   bool
+	rate float64 // mol/s
+}
+
+// NewO2Generator creates an oxygen generator with the given production rate
+func NewO2Generator(id string, rate float64) *O2Generator {
+	return &O2Generator{
+		BaseDevice: NewBaseDevice(id, time.Second),
+		on:         true,
+		rate:       rate,
+	}
+}
+
+// HandleInput processes generator commands
+// Commands: [on/off], ["rate", mol_per_s]
+func (g *O2Generator) HandleInput(msg Message) error {
+	if len(msg.Values) == 0 {
+		return fmt.Errorf("o2 generator %s: missing command", g.id)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if on, err := ToBool(msg.Values[0]); err == nil {
+		g.on = on
+		return nil
+	}
+	if strings.ToLower(toString(msg.Values[0])) != "rate" || len(msg.Values) < 2 {
+		return fmt.Errorf("o2 generator %s: unknown command %v", g.id, msg.Values[0])
+	}
+	rate, err := ToFloat(msg.Values[1])
+	if err != nil {
+		return fmt.Errorf("o2 generator %s: %w", g.id, err)
+	}
+	if rate < 0 {
+		return fmt.Errorf("o2 generator %s: rate cannot be negative", g.id)
+	}
+	g.rate = rate
+	return nil
+}
+
+// Health reports the generator off when switched off
+func (g *O2Generator) Health() Health {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.on {
+		return Health{Status: HealthOff}
+	}
+	return Health{Status: HealthNominal}
+}
+
+// Tick publishes the oxygen production rate
+func (g *O2Generator) Tick() error {
+	g.mu.Lock()
+	var rate float64
+	if g.on {
+		rate = g.rate
+	}
+	g.mu.Unlock()
+
+	if err := publishFlow(g.bus, g.id, g.Now(), gasFlow{o2: rate}); err != nil {
+		return err
+	}
+	return publishTelemetry(g.bus, "life_support", g.id, g.Now(), "rate", rate)
+}
@@ -2,11 +2,13 @@ package device
 
 import (
 	"log"
+	"sync"
 )
 
 // Logger represents a device that records incoming values
 type Logger struct {
 	*BaseDevice
+	mu     sync.Mutex
 	values []float64
 }
 
@@ -20,8 +22,16 @@ func NewLogger(id string) *Logger {
 	return l
 }
 
+// Subscribe registers the logger to receive messages on its topics
+func (l *Logger) Subscribe(bus Bus) error {
+	return l.SubscribeAs(bus, l)
+}
+
 // HandleInput processes incoming messages and logs the values
 func (l *Logger) HandleInput(msg Message) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
 	for _, v := range msg.Values {
 		if f, ok := v.(float64); ok {
 			l.values = append(l.values, f)
@@ -31,9 +41,14 @@ func (l *Logger) HandleInput(msg Message) error {
 	return nil
 }
 
-// GetValues returns the recorded values
+// GetValues returns a copy of the recorded values
 func (l *Logger) GetValues() []float64 {
-	return l.values
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	values := make([]float64, len(l.values))
+	copy(values, l.values)
+	return values
 }
 
 func (l *Logger) Tick() error {
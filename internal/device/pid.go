@@ -0,0 +1,270 @@
+package device
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Anti-windup strategies for the PID integrator
+const (
+	AntiWindupNone      = "none"
+	AntiWindupClamp     = "clamp"            // stop integrating while saturated
+	AntiWindupBackCalc  = "back_calculation" // bleed the integrator by the saturation error
+	defaultPIDTickRate  = 100 * time.Millisecond
+	defaultTrackingGain = 1.0
+)
+
+// Signal identifies a message on the bus
+type Signal struct {
+	Topic string
+	ID    string
+	// Values are sent before the output value, e.g. ["rate"] to command
+	// a device that expects ["rate", x]. Used for outputs only.
+	Values []interface{}
+}
+
+// PIDConfig holds the parameters of a PID controller
+type PIDConfig struct {
+	Measurement  Signal
+	Output       Signal
+	Setpoint     float64
+	Kp, Ki, Kd   float64
+	OutputMin    float64
+	OutputMax    float64
+	RateLimit    float64 // maximum output change per second, 0 disables
+	AntiWindup   string
+	TrackingGain float64 // back-calculation gain, 1/s
+	TickRate     time.Duration
+}
+
+// PID is a controller device that drives an actuator command from a
+// measurement on the bus towards a setpoint
+// It publishes its setpoint and output on the "control" topic
+type PID struct {
+	*BaseDevice
+	mu          sync.Mutex
+	cfg         PIDConfig
+	enabled     bool
+	measurement float64
+	hasMeas     bool
+	prevMeas    float64
+	integral    float64
+	output      float64
+	lastTime    time.Time
+}
+
+// NewPID creates a PID controller
+func NewPID(id string, cfg PIDConfig) (*PID, error) {
+	if cfg.Measurement.Topic == "" || cfg.Measurement.ID == "" {
+		return nil, fmt.Errorf("pid %s: measurement topic and ID are required", id)
+	}
+	if cfg.Output.ID == "" {
+		return nil, fmt.Errorf("pid %s: output ID is required", id)
+	}
+	if cfg.Output.Topic == "" {
+		cfg.Output.Topic = "commands"
+	}
+	if cfg.OutputMin > cfg.OutputMax {
+		return nil, fmt.Errorf("pid %s: output_min is greater than output_max", id)
+	}
+	if cfg.RateLimit < 0 {
+		return nil, fmt.Errorf("pid %s: rate limit cannot be negative", id)
+	}
+	switch cfg.AntiWindup {
+	case "":
+		cfg.AntiWindup = AntiWindupClamp
+	case AntiWindupNone, AntiWindupClamp, AntiWindupBackCalc:
+	default:
+		return nil, fmt.Errorf("pid %s: unknown anti-windup %s", id, cfg.AntiWindup)
+	}
+	if cfg.TrackingGain == 0 {
+		cfg.TrackingGain = defaultTrackingGain
+	}
+	if cfg.TickRate == 0 {
+		cfg.TickRate = defaultPIDTickRate
+	}
+
+	p := &PID{
+		BaseDevice: NewBaseDevice(id, cfg.TickRate),
+		cfg:        cfg,
+		enabled:    true,
+		output:     math.Max(cfg.OutputMin, math.Min(0, cfg.OutputMax)),
+	}
+	p.AddTopic(cfg.Measurement.Topic)
+	return p, nil
+}
+
+// Subscribe registers the controller to receive its measurement
+func (p *PID) Subscribe(bus Bus) error {
+	return p.SubscribeAs(bus, p)
+}
+
+// HandleInput tracks the measurement and processes controller commands
+// Commands: [on/off] or [true/false], [setpoint] with any number, 1 and 0
+// included, ["setpoint", x], ["kp"|"ki"|"kd", x] and ["reset"]
+func (p *PID) HandleInput(msg Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if msg.ID == p.cfg.Measurement.ID {
+		if len(msg.Values) == 0 {
+			return nil
+		}
+		v, err := ToFloat(msg.Values[0])
+		if err != nil {
+			return fmt.Errorf("pid %s: invalid measurement: %w", p.id, err)
+		}
+		p.measurement = v
+		p.hasMeas = true
+		return nil
+	}
+	if msg.ID != p.id {
+		return nil
+	}
+
+	if len(msg.Values) == 0 {
+		return fmt.Errorf("pid %s: missing command", p.id)
+	}
+	if on, ok := switchCommand(msg.Values[0]); ok {
+		p.enabled = on
+		return nil
+	}
+	if sp, err := ToFloat(msg.Values[0]); err == nil {
+		p.cfg.Setpoint = sp
+		return nil
+	}
+	cmd := strings.ToLower(toString(msg.Values[0]))
+	if cmd == "reset" {
+		p.integral = 0
+		p.lastTime = time.Time{}
+		return nil
+	}
+	if len(msg.Values) < 2 {
+		return fmt.Errorf("pid %s: missing value for %s", p.id, cmd)
+	}
+	val, err := ToFloat(msg.Values[1])
+	if err != nil {
+		return fmt.Errorf("pid %s: %w", p.id, err)
+	}
+	switch cmd {
+	case "setpoint":
+		p.cfg.Setpoint = val
+	case "kp":
+		p.cfg.Kp = val
+	case "ki":
+		p.cfg.Ki = val
+	case "kd":
+		p.cfg.Kd = val
+	default:
+		return fmt.Errorf("pid %s: unknown command %s", p.id, cmd)
+	}
+	return nil
+}
+
+// switchCommand reports whether a value switches the controller, true and
+// "on" enabling it, false and "off" disabling it
+// Unlike ToBool numbers are not switches, they are setpoints.
+func switchCommand(v interface{}) (on, ok bool) {
+	switch val := v.(type) {
+	case bool:
+		return val, true
+	case string:
+		switch strings.ToLower(strings.TrimSpace(val)) {
+		case "on", "true":
+			return true, true
+		case "off", "false":
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// Health reports the controller off when disabled
+func (p *PID) Health() Health {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.enabled {
+		return Health{Status: HealthOff}
+	}
+	return Health{Status: HealthNominal}
+}
+
+// Tick runs one control step using the simulation time since the last step
+func (p *PID) Tick() error {
+	p.mu.Lock()
+	if !p.enabled || !p.hasMeas {
+		p.mu.Unlock()
+		return nil
+	}
+
+	now := p.Now()
+	dt := p.tickRate.Seconds()
+	if !p.lastTime.IsZero() {
+		dt = now.Sub(p.lastTime).Seconds()
+	}
+	first := p.lastTime.IsZero()
+	p.lastTime = now
+	if dt <= 0 {
+		p.mu.Unlock()
+		return nil
+	}
+
+	p.output = p.step(dt, first)
+	output, setpoint := p.output, p.cfg.Setpoint
+	p.mu.Unlock()
+
+	values := append(append([]interface{}{}, p.cfg.Output.Values...), output)
+	msg := Message{
+		ID:     p.cfg.Output.ID,
+		Values: values,
+		Time:   now,
+		Source: p.id,
+	}
+	if err := p.bus.Publish(p.cfg.Output.Topic, msg); err != nil {
+		return fmt.Errorf("failed to publish controller output: %w", err)
+	}
+	if err := publishTelemetry(p.bus, "control", p.id, p.Now(), "setpoint", setpoint); err != nil {
+		return err
+	}
+	return publishTelemetry(p.bus, "control", p.id, p.Now(), "output", output)
+}
+
+// step computes the controller output for a time step of dt seconds
+// The caller must hold p.mu
+func (p *PID) step(dt float64, first bool) float64 {
+	e := p.cfg.Setpoint - p.measurement
+
+	// Differentiate the measurement rather than the error so setpoint
+	// changes do not kick the output
+	var derivative float64
+	if !first {
+		derivative = -p.cfg.Kd * (p.measurement - p.prevMeas) / dt
+	}
+	p.prevMeas = p.measurement
+
+	integral := p.integral + p.cfg.Ki*e*dt
+	raw := p.cfg.Kp*e + integral + derivative
+
+	out := math.Max(p.cfg.OutputMin, math.Min(raw, p.cfg.OutputMax))
+	if p.cfg.RateLimit > 0 {
+		maxStep := p.cfg.RateLimit * dt
+		out = math.Max(p.output-maxStep, math.Min(out, p.output+maxStep))
+	}
+
+	switch p.cfg.AntiWindup {
+	case AntiWindupClamp:
+		// Only integrate when it does not push further into saturation
+		if out == raw || (raw > out) != (e > 0) {
+			p.integral = integral
+		}
+	case AntiWindupBackCalc:
+		p.integral = integral + p.cfg.TrackingGain*(out-raw)*dt
+	default:
+		p.integral = integral
+	}
+	return out
+}
@@ -0,0 +1,255 @@
+package device
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultRecorderCapacity is the number of samples kept per parameter
+const defaultRecorderCapacity = 1024
+
+// Sample is a recorded parameter value
+type Sample struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// Stats summarises the samples of a parameter
+type Stats struct {
+	Count  int       `json:"count"`
+	Min    float64   `json:"min"`
+	Max    float64   `json:"max"`
+	Mean   float64   `json:"mean"`
+	StdDev float64   `json:"stddev"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+}
+
+// ring is a fixed-capacity buffer keeping the most recent samples
+type ring struct {
+	samples []Sample
+	start   int
+	count   int
+}
+
+// add appends a sample, overwriting the oldest when full
+func (r *ring) add(s Sample) {
+	if r.count < len(r.samples) {
+		r.samples[(r.start+r.count)%len(r.samples)] = s
+		r.count++
+		return
+	}
+	r.samples[r.start] = s
+	r.start = (r.start + 1) % len(r.samples)
+}
+
+// since returns a copy of the samples at or after t, oldest first
+func (r *ring) since(t time.Time) []Sample {
+	var out []Sample
+	for i := 0; i < r.count; i++ {
+		s := r.samples[(r.start+i)%len(r.samples)]
+		if !s.Time.Before(t) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Recorder is a device that keeps a bounded history of every numeric
+// parameter on its topics and answers statistics queries
+// Values after the first in a message are recorded as "<id>[<index>]"
+type Recorder struct {
+	*BaseDevice
+	mu       sync.RWMutex
+	capacity int
+	params   map[string]*ring
+}
+
+// NewRecorder creates a recorder for the given topics keeping capacity
+// samples per parameter
+func NewRecorder(id string, capacity int, topics ...string) *Recorder {
+	if capacity <= 0 {
+		capacity = defaultRecorderCapacity
+	}
+	r := &Recorder{
+		BaseDevice: NewBaseDevice(id, 0),
+		capacity:   capacity,
+		params:     make(map[string]*ring),
+	}
+	for _, topic := range topics {
+		r.AddTopic(topic)
+	}
+	return r
+}
+
+// Subscribe registers the recorder to receive messages on its topics
+func (r *Recorder) Subscribe(bus Bus) error {
+	return r.SubscribeAs(bus, r)
+}
+
+// HandleInput records the numeric values of a message
+func (r *Recorder) HandleInput(msg Message) error {
+	if msg.ID == r.id {
+		return fmt.Errorf("recorder %s: use a query", r.id)
+	}
+
+	t := msg.Time
+	if t.IsZero() {
+		t = r.Now()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, v := range msg.Values {
+		value, ok := numeric(v)
+		if !ok {
+			continue
+		}
+		name := msg.ID
+		if i > 0 {
+			name = fmt.Sprintf("%s[%d]", msg.ID, i)
+		}
+		buf, exists := r.params[name]
+		if !exists {
+			buf = &ring{samples: make([]Sample, r.capacity)}
+			r.params[name] = buf
+		}
+		buf.add(Sample{Time: t, Value: value})
+	}
+	return nil
+}
+
+// numeric converts numbers and booleans to float64
+func numeric(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case float64:
+		return val, true
+	case int:
+		return float64(val), true
+	case bool:
+		if val {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// Parameters returns the names of the recorded parameters
+func (r *Recorder) Parameters() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.params))
+	for name := range r.params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Samples returns a copy of the samples of a parameter recorded within
+// window of the current simulation time, or all of them for a zero window
+func (r *Recorder) Samples(param string, window time.Duration) ([]Sample, error) {
+	var from time.Time
+	if window > 0 {
+		from = r.Now().Add(-window)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	buf, exists := r.params[param]
+	if !exists {
+		return nil, fmt.Errorf("recorder %s: unknown parameter %s", r.id, param)
+	}
+	return buf.since(from), nil
+}
+
+// Stats returns min, max, mean and standard deviation of a parameter
+// over window, or over the whole history for a zero window
+func (r *Recorder) Stats(param string, window time.Duration) (Stats, error) {
+	samples, err := r.Samples(param, window)
+	if err != nil {
+		return Stats{}, err
+	}
+	if len(samples) == 0 {
+		return Stats{}, nil
+	}
+
+	st := Stats{
+		Count: len(samples),
+		Min:   math.Inf(1),
+		Max:   math.Inf(-1),
+		From:  samples[0].Time,
+		To:    samples[len(samples)-1].Time,
+	}
+	// Welford's algorithm for a numerically stable variance
+	var m2 float64
+	for i, s := range samples {
+		st.Min = math.Min(st.Min, s.Value)
+		st.Max = math.Max(st.Max, s.Value)
+		delta := s.Value - st.Mean
+		st.Mean += delta / float64(i+1)
+		m2 += delta * (s.Value - st.Mean)
+	}
+	st.StdDev = math.Sqrt(m2 / float64(len(samples)))
+	return st, nil
+}
+
+// Query answers client queries
+// Queries: ["params"], ["stats", param, window_s], ["samples", param, window_s]
+// The window is optional, zero or missing covers the whole history
+func (r *Recorder) Query(msg Message) ([]interface{}, error) {
+	if len(msg.Values) == 0 {
+		return nil, fmt.Errorf("recorder %s: missing query", r.id)
+	}
+
+	query := strings.ToLower(toString(msg.Values[0]))
+	if query == "params" {
+		var out []interface{}
+		for _, name := range r.Parameters() {
+			out = append(out, name)
+		}
+		return out, nil
+	}
+
+	if len(msg.Values) < 2 {
+		return nil, fmt.Errorf("recorder %s: %s needs a parameter", r.id, query)
+	}
+	param := toString(msg.Values[1])
+	var window time.Duration
+	if len(msg.Values) > 2 {
+		secs, err := ToFloat(msg.Values[2])
+		if err != nil {
+			return nil, fmt.Errorf("recorder %s: invalid window: %w", r.id, err)
+		}
+		window = time.Duration(secs * float64(time.Second))
+	}
+
+	switch query {
+	case "stats":
+		st, err := r.Stats(param, window)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{st}, nil
+	case "samples":
+		samples, err := r.Samples(param, window)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]interface{}, len(samples))
+		for i, s := range samples {
+			out[i] = s
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("recorder %s: unknown query %s", r.id, query)
+	}
+}
@@ -4,15 +4,45 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"strings"
+	"sync"
 	"time"
 )
 
+// Sensor publish policies
+const (
+	// PublishOnChange publishes when the measurement moves more than the
+	// deadband, and at least every heartbeat period when one is set
+	PublishOnChange = "on_change"
+	// PublishPeriodic publishes every period
+	PublishPeriodic = "periodic"
+	// PublishOnRequest publishes only when the sensor is polled
+	PublishOnRequest = "on_request"
+
+	// DefaultHeartbeat keeps a stable on-change sensor visible to the ground
+	DefaultHeartbeat = 10 * time.Second
+)
+
 // Sensor represents a read-only device that periodically generates values
+// The true value follows a random walk, or tracks a message from another
+// device when a truth source is set. Error models turn the true value into
+// the published measurement.
 type Sensor struct {
 	*BaseDevice
+	mu        sync.Mutex
 	value     float64
 	noise     float64
 	lastValue float64
+	truth     *Signal
+	models    []ErrorModel
+	topic     string // topic measurements are published on
+
+	policy      string
+	period      time.Duration
+	deadband    float64
+	heartbeat   time.Duration
+	measured    float64
+	lastPublish time.Time
 }
 
 // NewSensor creates a new sensor device
@@ -22,38 +52,219 @@ func NewSensor(id string, initialValue, noise float64) *Sensor {
 		value:      initialValue,
 		noise:      noise,
 		lastValue:  initialValue,
+		measured:   initialValue,
+		policy:     PublishOnChange,
+		period:     time.Second,
+		deadband:   noise / 2,
+		heartbeat:  DefaultHeartbeat,
+		topic:      "sensors",
 	}
 	s.AddTopic("sensors")
 	return s
 }
 
-// HandleInput processes incoming messages
+// SetPublishPolicy sets when the sensor publishes its measurement
+// period applies to the periodic policy, deadband and heartbeat to the
+// on-change policy. A zero heartbeat disables it. Periods are resolved
+// at the sensor's tick rate.
+func (s *Sensor) SetPublishPolicy(policy string, period time.Duration, deadband float64, heartbeat time.Duration) error {
+	switch policy {
+	case PublishOnChange, PublishPeriodic, PublishOnRequest:
+	default:
+		return fmt.Errorf("sensor %s: unknown publish policy %s", s.id, policy)
+	}
+	if period <= 0 && policy == PublishPeriodic {
+		return fmt.Errorf("sensor %s: period must be positive", s.id)
+	}
+	if deadband < 0 || heartbeat < 0 {
+		return fmt.Errorf("sensor %s: deadband and heartbeat cannot be negative", s.id)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policy = policy
+	if period > 0 {
+		s.period = period
+	}
+	s.deadband = deadband
+	s.heartbeat = heartbeat
+	return nil
+}
+
+// SetTruthSource makes the sensor measure the first value of messages with
+// the given ID on a topic instead of a random walk
+// It must be called before the sensor is registered
+func (s *Sensor) SetTruthSource(topic, id string) {
+	s.truth = &Signal{Topic: topic, ID: id}
+	s.AddTopic(topic)
+}
+
+// SetTopic sets the topic measurements are published on, e.g.
+// "sensors/cabin/pressure" so consumers can subscribe to "sensors/cabin/#"
+// It must be called before the sensor is registered
+func (s *Sensor) SetTopic(topic string) {
+	s.topic = topic
+}
+
+// AddErrorModel appends an error model to the measurement chain
+func (s *Sensor) AddErrorModel(m ErrorModel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.models = append(s.models, m)
+}
+
+// Subscribe registers the sensor to receive its truth source
+func (s *Sensor) Subscribe(bus Bus) error {
+	return s.SubscribeAs(bus, s)
+}
+
+// HandleInput tracks the truth source and processes publish commands
+// Commands: ["poll"] answering requests with the measurement, ["policy", on_change|periodic|on_request],
+// ["period", seconds], ["deadband", value], ["heartbeat", seconds]
 func (s *Sensor) HandleInput(msg Message) error {
-	// Sensors are read-only, so they ignore input
+	if msg.ID == s.id && msg.Source != s.id {
+		return s.handleCommand(msg)
+	}
+
+	// Sensors are read-only, so they ignore other input
+	if s.truth == nil || msg.ID != s.truth.ID || len(msg.Values) == 0 {
+		return nil
+	}
+	v, err := ToFloat(msg.Values[0])
+	if err != nil {
+		return fmt.Errorf("sensor %s: invalid truth value: %w", s.id, err)
+	}
+
+	s.mu.Lock()
+	s.value = v
+	s.mu.Unlock()
 	return nil
 }
 
-// Tick updates the sensor's value
-func (s *Sensor) Tick() error {
-	// Add some random noise to the value
-	noise := (rand.Float64()*2 - 1) * s.noise
-	s.value += noise
-
-	// Only publish if the value has changed significantly
-	if abs(s.value-s.lastValue) > s.noise/2 {
-		s.lastValue = s.value
-		msg := Message{
-			ID:     s.id,
-			Values: []interface{}{s.value},
-			Time:   time.Now(),
-			Source: s.id,
+// RepliesTo reports whether a request is a poll, answered with the
+// measurement
+func (s *Sensor) RepliesTo(msg Message) bool {
+	return msg.ID == s.id && len(msg.Values) > 0 && strings.ToLower(toString(msg.Values[0])) == "poll"
+}
+
+// handleCommand processes a publish policy command
+func (s *Sensor) handleCommand(msg Message) error {
+	if len(msg.Values) == 0 {
+		return fmt.Errorf("sensor %s: missing command", s.id)
+	}
+
+	cmd := strings.ToLower(toString(msg.Values[0]))
+	if cmd == "poll" {
+		now := s.Now()
+		s.mu.Lock()
+		measured := s.measured
+		s.lastValue = measured
+		s.lastPublish = now
+		s.mu.Unlock()
+		if err := s.publish(measured, now); err != nil {
+			return err
+		}
+		// Requests get the measurement as their reply
+		if msg.ReplyTo != "" {
+			return s.Reply(msg, []interface{}{measured})
+		}
+		return nil
+	}
+	if len(msg.Values) < 2 {
+		return fmt.Errorf("sensor %s: missing value for %s", s.id, cmd)
+	}
+
+	s.mu.Lock()
+	policy, period, deadband, heartbeat := s.policy, s.period, s.deadband, s.heartbeat
+	s.mu.Unlock()
+
+	switch cmd {
+	case "policy":
+		policy = toString(msg.Values[1])
+	case "period", "heartbeat":
+		secs, err := ToFloat(msg.Values[1])
+		if err != nil {
+			return fmt.Errorf("sensor %s: %w", s.id, err)
+		}
+		d := time.Duration(secs * float64(time.Second))
+		if cmd == "period" {
+			if d <= 0 {
+				return fmt.Errorf("sensor %s: period must be positive", s.id)
+			}
+			period = d
+		} else {
+			heartbeat = d
 		}
-		if err := s.bus.Publish("sensors", msg); err != nil {
-			return fmt.Errorf("failed to publish sensor value: %w", err)
+	case "deadband":
+		v, err := ToFloat(msg.Values[1])
+		if err != nil {
+			return fmt.Errorf("sensor %s: %w", s.id, err)
 		}
-		log.Printf("Sensor %s: %.2f", s.id, s.value)
+		deadband = v
+	default:
+		return fmt.Errorf("sensor %s: unknown command %s", s.id, cmd)
 	}
+	return s.SetPublishPolicy(policy, period, deadband, heartbeat)
+}
 
+// Tick samples the sensor and publishes according to its policy
+func (s *Sensor) Tick() error {
+	s.mu.Lock()
+	if s.truth == nil {
+		// Add some random noise to the value
+		noise := (rand.Float64()*2 - 1) * s.noise
+		s.value += noise
+	}
+
+	measured := s.value
+	now := s.Now()
+	for _, m := range s.models {
+		measured = m.Apply(measured, now)
+	}
+	s.measured = measured
+
+	publish := s.due(measured, now)
+	if publish {
+		s.lastValue = measured
+		s.lastPublish = now
+	}
+	s.mu.Unlock()
+
+	if publish {
+		return s.publish(measured, now)
+	}
+	return nil
+}
+
+// due reports whether a measurement should be published under the policy
+// The caller must hold s.mu
+func (s *Sensor) due(measured float64, now time.Time) bool {
+	sincePublish := now.Sub(s.lastPublish)
+	switch s.policy {
+	case PublishPeriodic:
+		return s.lastPublish.IsZero() || sincePublish >= s.period
+	case PublishOnChange:
+		if abs(measured-s.lastValue) > s.deadband {
+			return true
+		}
+		return s.heartbeat > 0 && (s.lastPublish.IsZero() || sincePublish >= s.heartbeat)
+	default:
+		return false
+	}
+}
+
+// publish sends a measurement on the sensor topic
+func (s *Sensor) publish(measured float64, now time.Time) error {
+	msg := Message{
+		ID:     s.id,
+		Values: []interface{}{measured},
+		Time:   now,
+		Source: s.id,
+	}
+	if err := s.bus.Publish(s.topic, msg); err != nil {
+		return fmt.Errorf("failed to publish sensor value: %w", err)
+	}
+	log.Printf("Sensor %s: %.2f", s.id, measured)
 	return nil
 }
 
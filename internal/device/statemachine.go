@@ -0,0 +1,251 @@
+package device
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// AnyState matches every state as the source of a transition
+	AnyState = "*"
+
+	defaultStateMachineTickRate = 100 * time.Millisecond
+)
+
+// State is a state of a state machine
+type State struct {
+	Name    string
+	OnEntry []Signal // messages published when the state is entered
+	OnExit  []Signal // messages published when the state is left
+	// Timeout moves the machine to TimeoutTo after the given simulation
+	// time in the state, 0 disables
+	Timeout   time.Duration
+	TimeoutTo string
+}
+
+// Transition is an allowed change of state
+type Transition struct {
+	From   string // state name or AnyState
+	To     string
+	Guards []Condition // must all hold on the latest watched values
+	// Auto takes the transition as soon as its guards hold, without
+	// waiting for a command
+	Auto bool
+}
+
+// StateMachineConfig holds the definition of a state machine
+type StateMachineConfig struct {
+	Initial     string
+	States      []State
+	Transitions []Transition
+	Watch       []string // topics providing the values used by guards
+	TickRate    time.Duration
+}
+
+// StateMachine is a device whose behaviour is defined by configuration
+// Clients request a state by name, e.g. ["Auto"]; illegal transitions are
+// rejected. The current state is published on the "modes" topic.
+type StateMachine struct {
+	*BaseDevice
+	mu      sync.Mutex
+	cfg     StateMachineConfig
+	states  map[string]*State
+	current string
+	entered time.Time
+	started bool
+	values  map[string]interface{}
+}
+
+// NewStateMachine creates a state machine device
+func NewStateMachine(id string, cfg StateMachineConfig) (*StateMachine, error) {
+	if len(cfg.States) == 0 {
+		return nil, fmt.Errorf("state machine %s: no states defined", id)
+	}
+
+	states := make(map[string]*State, len(cfg.States))
+	for i := range cfg.States {
+		st := &cfg.States[i]
+		if st.Name == "" || st.Name == AnyState {
+			return nil, fmt.Errorf("state machine %s: invalid state name %q", id, st.Name)
+		}
+		if _, exists := states[st.Name]; exists {
+			return nil, fmt.Errorf("state machine %s: duplicate state %s", id, st.Name)
+		}
+		states[st.Name] = st
+	}
+	if cfg.Initial == "" {
+		cfg.Initial = cfg.States[0].Name
+	}
+	if _, exists := states[cfg.Initial]; !exists {
+		return nil, fmt.Errorf("state machine %s: unknown initial state %s", id, cfg.Initial)
+	}
+	for _, st := range states {
+		if st.Timeout < 0 {
+			return nil, fmt.Errorf("state machine %s: state %s timeout cannot be negative", id, st.Name)
+		}
+		if st.Timeout > 0 {
+			if _, exists := states[st.TimeoutTo]; !exists {
+				return nil, fmt.Errorf("state machine %s: state %s times out to unknown state %q", id, st.Name, st.TimeoutTo)
+			}
+		}
+		for _, action := range append(append([]Signal{}, st.OnEntry...), st.OnExit...) {
+			if action.Topic == "" || action.ID == "" {
+				return nil, fmt.Errorf("state machine %s: state %s action needs a topic and an ID", id, st.Name)
+			}
+		}
+	}
+	for _, tr := range cfg.Transitions {
+		if _, exists := states[tr.From]; !exists && tr.From != AnyState {
+			return nil, fmt.Errorf("state machine %s: transition from unknown state %s", id, tr.From)
+		}
+		if _, exists := states[tr.To]; !exists {
+			return nil, fmt.Errorf("state machine %s: transition to unknown state %s", id, tr.To)
+		}
+	}
+	if cfg.TickRate == 0 {
+		cfg.TickRate = defaultStateMachineTickRate
+	}
+
+	sm := &StateMachine{
+		BaseDevice: NewBaseDevice(id, cfg.TickRate),
+		cfg:        cfg,
+		states:     states,
+		current:    cfg.Initial,
+		values:     make(map[string]interface{}),
+	}
+	for _, topic := range cfg.Watch {
+		sm.AddTopic(topic)
+	}
+	return sm, nil
+}
+
+// Subscribe registers the state machine to receive its watched topics
+func (sm *StateMachine) Subscribe(bus Bus) error {
+	return sm.SubscribeAs(bus, sm)
+}
+
+// State returns the current state
+func (sm *StateMachine) State() string {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.current
+}
+
+// HandleInput records watched values and processes state requests
+// Commands: [state] requests a transition to the named state
+func (sm *StateMachine) HandleInput(msg Message) error {
+	if msg.ID != sm.id {
+		if len(msg.Values) > 0 {
+			sm.mu.Lock()
+			sm.values[msg.ID] = msg.Values[0]
+			sm.mu.Unlock()
+		}
+		return nil
+	}
+
+	if len(msg.Values) == 0 {
+		return fmt.Errorf("state machine %s: missing state", sm.id)
+	}
+	target := toString(msg.Values[0])
+
+	sm.mu.Lock()
+	if target == sm.current {
+		sm.mu.Unlock()
+		return nil
+	}
+	if _, exists := sm.states[target]; !exists {
+		sm.mu.Unlock()
+		return fmt.Errorf("state machine %s: unknown state %s", sm.id, target)
+	}
+
+	var failed []string
+	for _, tr := range sm.cfg.Transitions {
+		if tr.To != target || (tr.From != sm.current && tr.From != AnyState) {
+			continue
+		}
+		if guard, ok := EvalAll(tr.Guards, sm.values); !ok {
+			failed = append(failed, guard.String())
+			continue
+		}
+		actions := sm.enter(target)
+		sm.mu.Unlock()
+		return sm.publishActions(actions)
+	}
+	from := sm.current
+	sm.mu.Unlock()
+
+	if len(failed) > 0 {
+		return fmt.Errorf("state machine %s: transition from %s to %s blocked by guard %s",
+			sm.id, from, target, strings.Join(failed, ", "))
+	}
+	return fmt.Errorf("state machine %s: illegal transition from %s to %s", sm.id, from, target)
+}
+
+// Tick enters the initial state, then takes automatic and timeout transitions
+func (sm *StateMachine) Tick() error {
+	sm.mu.Lock()
+	var actions []Signal
+	if !sm.started {
+		sm.started = true
+		sm.entered = sm.Now()
+		actions = append(actions, sm.states[sm.current].OnEntry...)
+		actions = append(actions, sm.stateSignal())
+	} else if st := sm.states[sm.current]; st.Timeout > 0 && sm.Now().Sub(sm.entered) >= st.Timeout {
+		log.Printf("State machine %s: %s timed out after %v", sm.id, st.Name, st.Timeout)
+		actions = sm.enter(st.TimeoutTo)
+	} else {
+		for _, tr := range sm.cfg.Transitions {
+			if !tr.Auto || tr.To == sm.current || (tr.From != sm.current && tr.From != AnyState) {
+				continue
+			}
+			if _, ok := EvalAll(tr.Guards, sm.values); ok {
+				actions = sm.enter(tr.To)
+				break
+			}
+		}
+	}
+	sm.mu.Unlock()
+
+	// Actions are published without the lock as they may be routed back
+	// to this device
+	return sm.publishActions(actions)
+}
+
+// enter moves to a new state and returns the exit and entry actions
+// followed by the state telemetry
+// The caller must hold sm.mu
+func (sm *StateMachine) enter(target string) []Signal {
+	log.Printf("State machine %s: %s -> %s", sm.id, sm.current, target)
+
+	actions := append([]Signal{}, sm.states[sm.current].OnExit...)
+	sm.current = target
+	sm.entered = sm.Now()
+	sm.started = true
+	actions = append(actions, sm.states[target].OnEntry...)
+	return append(actions, sm.stateSignal())
+}
+
+// stateSignal returns the telemetry message announcing the current state
+// The caller must hold sm.mu
+func (sm *StateMachine) stateSignal() Signal {
+	return Signal{Topic: "modes", ID: sm.id, Values: []interface{}{sm.current}}
+}
+
+// publishActions publishes action messages in order
+func (sm *StateMachine) publishActions(actions []Signal) error {
+	for _, action := range actions {
+		msg := Message{
+			ID:     action.ID,
+			Values: action.Values,
+			Time:   sm.Now(),
+			Source: sm.id,
+		}
+		if err := sm.bus.Publish(action.Topic, msg); err != nil {
+			return fmt.Errorf("state machine %s: failed to publish action: %w", sm.id, err)
+		}
+	}
+	return nil
+}
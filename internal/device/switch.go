@@ -0,0 +1,74 @@
+package device
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Switch is a power switch or distribution channel
+// Devices configured downstream of a switch go silent while it is off.
+// It publishes its state on the "power" topic.
+type Switch struct {
+	*BaseDevice
+	mu sync.Mutex
+	on bool
+}
+
+// NewSwitch creates a switch in the given state
+func NewSwitch(id string, on bool) *Switch {
+	return &Switch{
+		BaseDevice: NewBaseDevice(id, time.Second),
+		on:         on,
+	}
+}
+
+// HandleInput processes switch commands
+// Commands: [on/off]
+func (s *Switch) HandleInput(msg Message) error {
+	if len(msg.Values) == 0 {
+		return fmt.Errorf("switch %s: missing state", s.id)
+	}
+	on, err := ToBool(msg.Values[0])
+	if err != nil {
+		return fmt.Errorf("switch %s: %w", s.id, err)
+	}
+
+	s.mu.Lock()
+	s.on = on
+	s.mu.Unlock()
+	return s.publish(on)
+}
+
+// Health reports the switch off when open
+func (s *Switch) Health() Health {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.on {
+		return Health{Status: HealthOff}
+	}
+	return Health{Status: HealthNominal}
+}
+
+// Tick publishes the switch state
+func (s *Switch) Tick() error {
+	s.mu.Lock()
+	on := s.on
+	s.mu.Unlock()
+	return s.publish(on)
+}
+
+// publish sends the switch state with the switch ID so clients can show it
+func (s *Switch) publish(on bool) error {
+	msg := Message{
+		ID:     s.id,
+		Values: []interface{}{on},
+		Time:   s.Now(),
+		Source: s.id,
+	}
+	if err := s.bus.Publish("power", msg); err != nil {
+		return fmt.Errorf("switch %s: failed to publish state: %w", s.id, err)
+	}
+	return nil
+}
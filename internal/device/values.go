@@ -0,0 +1,88 @@
+package device
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ToFloat converts a message value to float64
+// Values arrive as float64 from JSON clients and as strings from the TUI
+func ToFloat(v interface{}) (float64, error) {
+	switch val := v.(type) {
+	case float64:
+		return val, nil
+	case int:
+		return float64(val), nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number %q", val)
+		}
+		return f, nil
+	default:
+		return 0, fmt.Errorf("invalid number %v", v)
+	}
+}
+
+// ToBool converts a message value to bool
+// Accepts true/false, on/off, 1/0 in string or numeric form
+func ToBool(v interface{}) (bool, error) {
+	switch val := v.(type) {
+	case bool:
+		return val, nil
+	case float64:
+		return val != 0, nil
+	case string:
+		switch strings.ToLower(strings.TrimSpace(val)) {
+		case "true", "on", "1":
+			return true, nil
+		case "false", "off", "0":
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("invalid boolean %v", v)
+}
+
+// toString converts a message value to string
+func toString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// PayloadBytes estimates the bytes the values of a message take when
+// sent, e.g. across an emulated bus
+// Booleans take a byte, floats eight and strings their length; other
+// values are counted by their JSON encoding.
+func PayloadBytes(msg Message) int {
+	bytes := 0
+	for _, v := range msg.Values {
+		switch v := v.(type) {
+		case bool:
+			bytes++
+		case int32, uint32, float32:
+			bytes += 4
+		case int, int64, uint64, float64:
+			bytes += 8
+		case string:
+			bytes += len(v)
+		default:
+			data, err := json.Marshal(v)
+			if err != nil {
+				bytes += 64
+			} else {
+				bytes += len(data)
+			}
+		}
+	}
+	return bytes
+}
+
+// Milliseconds converts a duration to fractional milliseconds
+func Milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
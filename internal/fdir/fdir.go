@@ -0,0 +1,233 @@
+package fdir
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"spacecraftsim/internal/device"
+	"spacecraftsim/internal/ship"
+)
+
+// Action is a command issued by a rule
+type Action struct {
+	ID     string
+	Values []interface{}
+	After  time.Duration // simulation time to wait after the previous action
+}
+
+// Rule detects a fault with a monitor and isolates and recovers from it
+// The monitor is the fault condition on a parameter published on Topic,
+// e.g. "cabin.ppo2 < 19.5". The rule triggers once the condition holds for
+// Persistence consecutive samples and re-arms when it clears.
+type Rule struct {
+	ID          string
+	Topic       string
+	Monitor     device.Condition
+	Persistence int
+	Isolation   []Action // issued at once when the rule triggers
+	Recovery    []Action // issued in order after isolation
+}
+
+// ruleState tracks a rule at runtime
+type ruleState struct {
+	Rule
+	enabled   bool
+	count     int // consecutive samples meeting the monitor condition
+	triggered bool
+	triggers  int
+	lastValue interface{}
+}
+
+// Engine is a fault detection, isolation and recovery device
+// It observes telemetry on the bus and commands devices through the ship.
+// Triggered rules, clearances and failed actions are recorded in the ship
+// event log as "fdir.<rule>.triggered", "fdir.<rule>.cleared" and
+// "fdir.<rule>.action_failed".
+type Engine struct {
+	*device.BaseDevice
+	ship  *ship.Ship
+	mu    sync.Mutex
+	rules []*ruleState
+}
+
+// NewEngine creates an FDIR engine with the given rules
+func NewEngine(id string, s *ship.Ship, rules []Rule) (*Engine, error) {
+	e := &Engine{
+		BaseDevice: device.NewBaseDevice(id, 0),
+		ship:       s,
+	}
+
+	topics := make(map[string]bool)
+	ids := make(map[string]bool)
+	for _, r := range rules {
+		if r.ID == "" || ids[r.ID] {
+			return nil, fmt.Errorf("fdir: missing or duplicate rule ID %q", r.ID)
+		}
+		ids[r.ID] = true
+		if r.Topic == "" {
+			return nil, fmt.Errorf("fdir: rule %s needs a topic", r.ID)
+		}
+		if r.Persistence < 1 {
+			r.Persistence = 1
+		}
+		for _, a := range append(append([]Action{}, r.Isolation...), r.Recovery...) {
+			if a.ID == "" {
+				return nil, fmt.Errorf("fdir: rule %s has an action without a device", r.ID)
+			}
+			if a.After < 0 {
+				return nil, fmt.Errorf("fdir: rule %s has an action with a negative delay", r.ID)
+			}
+		}
+		for _, a := range r.Isolation {
+			if a.After != 0 {
+				return nil, fmt.Errorf("fdir: rule %s has a delayed isolation action", r.ID)
+			}
+		}
+
+		e.rules = append(e.rules, &ruleState{Rule: r, enabled: true})
+		if !topics[r.Topic] {
+			topics[r.Topic] = true
+			e.AddTopic(r.Topic)
+		}
+	}
+	return e, nil
+}
+
+// Subscribe registers the engine to receive the monitored topics
+func (e *Engine) Subscribe(bus device.Bus) error {
+	return e.SubscribeAs(bus, e)
+}
+
+// HandleInput evaluates the monitors of the rules watching the message and
+// executes commands sent to the engine
+func (e *Engine) HandleInput(msg device.Message) error {
+	if msg.ID == e.ID() {
+		_, err := e.Query(msg)
+		return err
+	}
+	if len(msg.Values) == 0 {
+		return nil
+	}
+
+	var triggered, cleared []*ruleState
+	e.mu.Lock()
+	for _, r := range e.rules {
+		if !r.enabled || r.Monitor.Param != msg.ID {
+			continue
+		}
+		r.lastValue = msg.Values[0]
+		if !r.Monitor.Eval(msg.Values[0]) {
+			r.count = 0
+			if r.triggered {
+				r.triggered = false
+				cleared = append(cleared, r)
+			}
+			continue
+		}
+		r.count++
+		if r.count >= r.Persistence && !r.triggered {
+			r.triggered = true
+			r.triggers++
+			triggered = append(triggered, r)
+		}
+	}
+	e.mu.Unlock()
+
+	// Act without the lock, actions may publish telemetry watched by the
+	// engine
+	for _, r := range cleared {
+		e.ship.RecordEvent("fdir."+r.ID+".cleared", r.Monitor.String())
+	}
+	for _, r := range triggered {
+		e.execute(r.Rule, msg.Values[0])
+	}
+	return nil
+}
+
+// execute records a triggered rule and issues its isolation and recovery
+// actions. Delayed actions are stored in the onboard sequencer.
+func (e *Engine) execute(r Rule, value interface{}) {
+	log.Printf("FDIR rule %s triggered: %s (value %v)", r.ID, r.Monitor, value)
+	e.ship.RecordEvent("fdir."+r.ID+".triggered", r.Monitor.String(), value, r.Persistence)
+
+	var delay time.Duration
+	for _, a := range append(append([]Action{}, r.Isolation...), r.Recovery...) {
+		delay += a.After
+		msg := device.Message{
+			ID:     a.ID,
+			Values: a.Values,
+			Time:   e.Now(),
+			Source: e.ID(),
+		}
+
+		var err error
+		if delay == 0 {
+			err = e.ship.HandleMessage(msg)
+		} else {
+			_, err = e.ship.Schedule(msg, e.ship.SimTime()+delay)
+		}
+		if err != nil {
+			log.Printf("FDIR rule %s: action on %s failed: %v", r.ID, a.ID, err)
+			e.ship.RecordEvent("fdir."+r.ID+".action_failed", a.ID, err.Error())
+		}
+	}
+}
+
+// Query answers engine commands
+// Commands: ["status"], ["enable", rule], ["disable", rule], ["reset", rule]
+// to re-arm a triggered rule
+func (e *Engine) Query(msg device.Message) ([]interface{}, error) {
+	if len(msg.Values) == 0 {
+		return nil, fmt.Errorf("fdir: missing command")
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	cmd := strings.ToLower(fmt.Sprint(msg.Values[0]))
+	if cmd == "status" {
+		var out []interface{}
+		for _, r := range e.rules {
+			out = append(out, map[string]interface{}{
+				"rule":      r.ID,
+				"monitor":   r.Monitor.String(),
+				"enabled":   r.enabled,
+				"count":     r.count,
+				"triggered": r.triggered,
+				"triggers":  r.triggers,
+				"value":     r.lastValue,
+			})
+		}
+		return out, nil
+	}
+
+	if len(msg.Values) != 2 {
+		return nil, fmt.Errorf("fdir: %s needs a rule", cmd)
+	}
+	var rule *ruleState
+	for _, r := range e.rules {
+		if r.ID == fmt.Sprint(msg.Values[1]) {
+			rule = r
+		}
+	}
+	if rule == nil {
+		return nil, fmt.Errorf("fdir: unknown rule %v", msg.Values[1])
+	}
+
+	switch cmd {
+	case "enable":
+		rule.enabled = true
+	case "disable":
+		rule.enabled = false
+		rule.count = 0
+	case "reset":
+		rule.count = 0
+		rule.triggered = false
+	default:
+		return nil, fmt.Errorf("fdir: unknown command %s", cmd)
+	}
+	return []interface{}{rule.ID}, nil
+}
@@ -0,0 +1,139 @@
+// Package fieldbus connects emulated avionics buses to the ship bus
+// Devices behind an attachment point of an emulated bus, a remote terminal
+// or a CAN node, publish and subscribe through a Port. The topics the
+// emulated bus carries cross it with its timing; other topics go straight
+// to the downstream bus.
+package fieldbus
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"spacecraftsim/internal/device"
+)
+
+// Channel delivers the messages of a topic from the downstream bus to the
+// devices behind an emulated bus
+// A proxy subscribes to the downstream bus while devices are subscribed
+// to the channel and passes the messages to Carry, which sends them
+// across the emulated bus; the bus then delivers them to the receivers.
+type Channel struct {
+	Proxy string                   // device ID of the proxy
+	Carry func(msg device.Message) // called without the bus lock
+
+	receivers []device.Device
+	proxy     *proxy
+}
+
+// Receivers returns the devices subscribed to the channel
+// The caller must hold the lock of the emulated bus
+func (c *Channel) Receivers() []device.Device {
+	return append([]device.Device(nil), c.receivers...)
+}
+
+// Port is the bus as seen by the devices behind an attachment point
+type Port struct {
+	Name       string // names the emulated bus in errors, e.g. "can can0"
+	Downstream device.Bus
+	Lock       sync.Locker // lock of the emulated bus, guards the channels
+	// Send carries a message published by a device across the emulated
+	// bus and reports whether the bus carries the topic at all
+	Send func(topic string, msg device.Message) bool
+	// Channel returns the channel delivering a topic to the devices, nil
+	// when they receive it from the downstream bus directly
+	Channel func(topic string) *Channel
+}
+
+// Publish sends a message across the emulated bus, messages on topics it
+// does not carry go to the downstream bus at once
+func (p *Port) Publish(topic string, msg device.Message) error {
+	if p.Send(topic, msg) {
+		return nil
+	}
+	return p.Downstream.Publish(topic, msg)
+}
+
+// Subscribe receives a topic through its channel, other topics directly
+// from the downstream bus
+func (p *Port) Subscribe(topic string, dev device.Device) error {
+	c := p.Channel(topic)
+	if c == nil {
+		return p.Downstream.Subscribe(topic, dev)
+	}
+
+	p.Lock.Lock()
+	for _, r := range c.receivers {
+		if r == dev {
+			p.Lock.Unlock()
+			return nil
+		}
+	}
+	c.receivers = append(c.receivers, dev)
+	px := c.proxy
+	if px == nil {
+		px = &proxy{BaseDevice: device.NewBaseDevice(c.Proxy, 0), channel: c}
+		c.proxy = px
+	}
+	p.Lock.Unlock()
+
+	// Subscribe without the lock, retained messages are carried at once
+	return p.Downstream.Subscribe(topic, px)
+}
+
+// Unsubscribe removes a subscription of a device
+func (p *Port) Unsubscribe(topic string, dev device.Device) error {
+	c := p.Channel(topic)
+	if c == nil {
+		return p.Downstream.Unsubscribe(topic, dev)
+	}
+
+	p.Lock.Lock()
+	for i, r := range c.receivers {
+		if r == dev {
+			c.receivers = append(c.receivers[:i], c.receivers[i+1:]...)
+			break
+		}
+	}
+	px := c.proxy
+	if len(c.receivers) > 0 || px == nil {
+		p.Lock.Unlock()
+		return nil
+	}
+	c.proxy = nil
+	p.Lock.Unlock()
+	return p.Downstream.Unsubscribe(topic, px)
+}
+
+// Request passes requests to the downstream bus, they do not cross the
+// emulated bus
+func (p *Port) Request(topic string, msg device.Message, timeout time.Duration) (device.Message, error) {
+	r, ok := p.Downstream.(device.Requester)
+	if !ok {
+		return device.Message{}, fmt.Errorf("%s: downstream bus does not support requests", p.Name)
+	}
+	return r.Request(topic, msg, timeout)
+}
+
+// proxy subscribes to the downstream bus for the receivers of a channel
+type proxy struct {
+	*device.BaseDevice
+	channel *Channel
+}
+
+// HandleInput carries a message across the emulated bus
+func (p *proxy) HandleInput(msg device.Message) error {
+	p.channel.Carry(msg)
+	return nil
+}
+
+// Deliver passes a message that crossed an emulated bus to its receivers,
+// logging their errors
+func Deliver(name string, receivers []device.Device, msg device.Message) {
+	for _, dev := range receivers {
+		if err := dev.HandleInput(msg); err != nil {
+			log.Printf("%s: error delivering %s to %s: %v", name, msg.ID, dev.ID(), err)
+		}
+	}
+}
@@ -0,0 +1,418 @@
+// Package mil1553 emulates a MIL-STD-1553 style command/response data bus
+// The bus controller runs a schedule of transfers from and to remote
+// terminals in minor frames, and a message only crosses the bus when its
+// transfer runs, taking the time its words need on the wire.
+package mil1553
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"spacecraftsim/internal/device"
+	"spacecraftsim/internal/fieldbus"
+)
+
+// Defaults of a 1 Mbit/s bus with 20 bit words and a 50 Hz minor frame
+const (
+	DefaultMinorFrame   = 20 * time.Millisecond
+	DefaultMinorFrames  = 50
+	DefaultWordTime     = 20 * time.Microsecond
+	DefaultResponseTime = 8 * time.Microsecond
+	DefaultGap          = 4 * time.Microsecond
+	DefaultQueueSize    = 64
+
+	// maxDataWords is the number of data words a transfer carries at most
+	maxDataWords = 32
+	// maxAddress is the highest remote terminal address, 31 is broadcast
+	maxAddress = 30
+	// maxSubaddress is the highest subaddress, 0 and 31 are mode codes
+	maxSubaddress = 30
+)
+
+// Transfer is a scheduled transfer between the bus controller and a
+// subaddress of a remote terminal
+// Every subaddress carries the messages of one topic.
+type Transfer struct {
+	Terminal   int
+	Subaddress int
+	Topic      string
+	Transmit   bool  // from the terminal to the controller, otherwise to the terminal
+	Frames     []int // minor frames of the major frame the transfer runs in, all when empty
+}
+
+// Config holds the timing and the schedule of the bus
+type Config struct {
+	MinorFrame   time.Duration // rounded up to the ship step by the ship loop
+	MinorFrames  int           // minor frames per major frame
+	WordTime     time.Duration // time on the wire per word
+	ResponseTime time.Duration // time a terminal takes to answer a command
+	Gap          time.Duration // gap between transfers
+	QueueSize    int           // messages waiting per subaddress, the oldest are dropped
+	Schedule     []Transfer    // in execution order within a minor frame
+}
+
+// queued is a message waiting for its transfer
+type queued struct {
+	msg    device.Message
+	words  int // data words of the message
+	sent   int // data words already transferred
+	queued time.Time
+}
+
+// slot is a scheduled transfer at runtime
+type slot struct {
+	Transfer
+	frames  map[int]bool // nil for every minor frame
+	queue   []queued
+	channel *fieldbus.Channel // transfers to the terminal only
+
+	transfers  int // transfers run
+	messages   int // messages delivered
+	dropped    int // messages dropped from a full queue
+	overruns   int // transfers skipped because the minor frame was full
+	latency    time.Duration
+	maxLatency time.Duration
+}
+
+// runs reports whether the transfer runs in a minor frame
+func (s *slot) runs(minor int) bool {
+	return s.frames == nil || s.frames[minor]
+}
+
+// direction names the direction of the transfer
+func (s *slot) direction() string {
+	if s.Transmit {
+		return "rt_to_bc"
+	}
+	return "bc_to_rt"
+}
+
+// delivery is a message whose transfer has completed
+type delivery struct {
+	slot *slot
+	msg  device.Message
+}
+
+// Bus is the bus controller of an emulated MIL-STD-1553 bus
+// Devices behind a remote terminal publish and subscribe through the
+// terminal's view of the bus. Their messages on a topic scheduled for the
+// terminal wait in the subaddress queue until the transfer runs and then
+// continue on the downstream bus; messages on unscheduled topics bypass
+// the avionics bus. Transfers that no longer fit in a minor frame are
+// skipped, so a saturated bus delays and eventually drops messages.
+// The controller ticks once per minor frame and reports itself degraded
+// while transfers are skipped.
+type Bus struct {
+	*device.BaseDevice
+	cfg        Config
+	downstream device.Bus
+	mu         sync.Mutex
+	slots      []*slot // in schedule order
+
+	minor       int // next minor frame
+	frames      int // minor frames run
+	busy        time.Duration
+	maxBusy     time.Duration
+	overruns    int
+	lastMajor   int // overruns in the previous major frame
+	thisMajor   int // overruns in the current major frame
+	unscheduled int // messages that bypassed the bus
+}
+
+// New creates a bus controller carrying messages to and from the
+// downstream bus
+func New(id string, downstream device.Bus, cfg Config) (*Bus, error) {
+	if cfg.MinorFrame == 0 {
+		cfg.MinorFrame = DefaultMinorFrame
+	}
+	if cfg.MinorFrames == 0 {
+		cfg.MinorFrames = DefaultMinorFrames
+	}
+	if cfg.WordTime == 0 {
+		cfg.WordTime = DefaultWordTime
+	}
+	if cfg.ResponseTime == 0 {
+		cfg.ResponseTime = DefaultResponseTime
+	}
+	if cfg.Gap == 0 {
+		cfg.Gap = DefaultGap
+	}
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = DefaultQueueSize
+	}
+	if cfg.MinorFrame < 0 || cfg.MinorFrames < 0 || cfg.WordTime < 0 || cfg.ResponseTime < 0 || cfg.Gap < 0 || cfg.QueueSize < 0 {
+		return nil, fmt.Errorf("mil1553 %s: timing and queue size cannot be negative", id)
+	}
+
+	b := &Bus{
+		BaseDevice: device.NewBaseDevice(id, cfg.MinorFrame),
+		cfg:        cfg,
+		downstream: downstream,
+	}
+	used := make(map[string]bool)
+	for _, t := range cfg.Schedule {
+		if t.Terminal < 0 || t.Terminal > maxAddress {
+			return nil, fmt.Errorf("mil1553 %s: terminal address %d out of range 0-%d", id, t.Terminal, maxAddress)
+		}
+		if t.Subaddress < 1 || t.Subaddress > maxSubaddress {
+			return nil, fmt.Errorf("mil1553 %s: subaddress %d out of range 1-%d", id, t.Subaddress, maxSubaddress)
+		}
+		if t.Topic == "" || strings.ContainsAny(t.Topic, "+#") {
+			return nil, fmt.Errorf("mil1553 %s: transfer to RT %d SA %d needs a topic without wildcards", id, t.Terminal, t.Subaddress)
+		}
+		for _, key := range []string{
+			fmt.Sprintf("%d/%d/%t", t.Terminal, t.Subaddress, t.Transmit),
+			fmt.Sprintf("%d/%s/%t", t.Terminal, t.Topic, t.Transmit),
+		} {
+			if used[key] {
+				return nil, fmt.Errorf("mil1553 %s: RT %d has two transfers in the same direction for SA %d or topic %s", id, t.Terminal, t.Subaddress, t.Topic)
+			}
+			used[key] = true
+		}
+
+		s := &slot{Transfer: t}
+		if !t.Transmit {
+			s.channel = &fieldbus.Channel{
+				Proxy: fmt.Sprintf("%s.rt%d.sa%d", id, t.Terminal, t.Subaddress),
+				Carry: func(msg device.Message) { b.enqueue(s, msg) },
+			}
+		}
+		if len(t.Frames) > 0 {
+			s.frames = make(map[int]bool, len(t.Frames))
+			for _, f := range t.Frames {
+				if f < 0 || f >= cfg.MinorFrames {
+					return nil, fmt.Errorf("mil1553 %s: minor frame %d out of range 0-%d", id, f, cfg.MinorFrames-1)
+				}
+				s.frames[f] = true
+			}
+		}
+		b.slots = append(b.slots, s)
+	}
+	return b, nil
+}
+
+// Terminal returns the bus as seen by the devices behind a remote
+// terminal address
+// Their messages on a topic scheduled from the terminal wait for the
+// transfer, and they receive the topics scheduled to the terminal through
+// its subaddresses.
+func (b *Bus) Terminal(address int) (device.Bus, error) {
+	if address < 0 || address > maxAddress {
+		return nil, fmt.Errorf("mil1553 %s: terminal address %d out of range 0-%d", b.ID(), address, maxAddress)
+	}
+	return &fieldbus.Port{
+		Name:       "mil1553 " + b.ID(),
+		Downstream: b.downstream,
+		Lock:       &b.mu,
+		Send: func(topic string, msg device.Message) bool {
+			if s := b.slot(address, topic, true); s != nil {
+				b.enqueue(s, msg)
+				return true
+			}
+			b.mu.Lock()
+			b.unscheduled++
+			b.mu.Unlock()
+			return false
+		},
+		Channel: func(topic string) *fieldbus.Channel {
+			if s := b.slot(address, topic, false); s != nil {
+				return s.channel
+			}
+			return nil
+		},
+	}, nil
+}
+
+// slot returns the transfer of a terminal for a topic, nil when the topic
+// is not scheduled
+func (b *Bus) slot(address int, topic string, transmit bool) *slot {
+	for _, s := range b.slots {
+		if s.Terminal == address && s.Topic == topic && s.Transmit == transmit {
+			return s
+		}
+	}
+	return nil
+}
+
+// enqueue stores a message until the transfer of its slot runs
+func (b *Bus) enqueue(s *slot, msg device.Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(s.queue) >= b.cfg.QueueSize {
+		s.queue = s.queue[1:]
+		s.dropped++
+	}
+	s.queue = append(s.queue, queued{msg: msg, words: dataWords(msg), queued: b.Now()})
+}
+
+// Tick runs the transfers of the next minor frame and delivers the
+// messages they complete
+func (b *Bus) Tick() error {
+	start := b.Now()
+
+	b.mu.Lock()
+	minor := b.minor
+	b.minor = (b.minor + 1) % b.cfg.MinorFrames
+	b.frames++
+	if minor == 0 {
+		b.lastMajor, b.thisMajor = b.thisMajor, 0
+	}
+
+	var elapsed time.Duration
+	var done []delivery
+	for _, s := range b.slots {
+		if !s.runs(minor) {
+			continue
+		}
+		words := 0
+		for _, q := range s.queue {
+			words += q.words - q.sent
+		}
+		if words > maxDataWords {
+			words = maxDataWords
+		}
+		cost := b.transferTime(words)
+		if elapsed+cost > b.cfg.MinorFrame {
+			s.overruns++
+			b.overruns++
+			b.thisMajor++
+			continue
+		}
+		elapsed += cost
+		s.transfers++
+
+		// Messages longer than a transfer continue in the next one
+		end := start.Add(elapsed)
+		for words > 0 {
+			head := &s.queue[0]
+			n := head.words - head.sent
+			if n > words {
+				n = words
+			}
+			head.sent += n
+			words -= n
+			if head.sent < head.words {
+				break
+			}
+			latency := end.Sub(head.queued)
+			s.latency += latency
+			if latency > s.maxLatency {
+				s.maxLatency = latency
+			}
+			s.messages++
+			done = append(done, delivery{slot: s, msg: head.msg})
+			s.queue = s.queue[1:]
+		}
+	}
+	b.busy = elapsed
+	if elapsed > b.maxBusy {
+		b.maxBusy = elapsed
+	}
+	receivers := make(map[*slot][]device.Device)
+	for _, d := range done {
+		if !d.slot.Transmit {
+			receivers[d.slot] = d.slot.channel.Receivers()
+		}
+	}
+	b.mu.Unlock()
+
+	// Deliver without the lock, receivers may publish in response
+	for _, d := range done {
+		if d.slot.Transmit {
+			if err := b.downstream.Publish(d.slot.Topic, d.msg); err != nil {
+				log.Printf("mil1553 %s: error publishing %s from RT %d: %v", b.ID(), d.msg.ID, d.slot.Terminal, err)
+			}
+			continue
+		}
+		fieldbus.Deliver("mil1553 "+b.ID(), receivers[d.slot], d.msg)
+	}
+	return nil
+}
+
+// transferTime returns the time a transfer of a number of data words
+// occupies the bus: the command word, the data words, the response time
+// of the terminal, its status word and the gap to the next transfer
+func (b *Bus) transferTime(words int) time.Duration {
+	return time.Duration(words+2)*b.cfg.WordTime + b.cfg.ResponseTime + b.cfg.Gap
+}
+
+// dataWords returns the number of 16 bit data words a message occupies,
+// at least one
+func dataWords(msg device.Message) int {
+	words := (device.PayloadBytes(msg) + 1) / 2
+	if words == 0 {
+		words = 1
+	}
+	return words
+}
+
+// Health reports the bus degraded while transfers are skipped for lack of
+// time in their minor frame
+func (b *Bus) Health() device.Health {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if overruns := b.lastMajor + b.thisMajor; overruns > 0 {
+		return device.Health{
+			Status: device.HealthDegraded,
+			Code:   "bus_saturated",
+			Reason: fmt.Sprintf("%d transfers skipped in the last major frame", overruns),
+		}
+	}
+	return device.Health{Status: device.HealthNominal}
+}
+
+// HandleInput executes bus controller commands
+func (b *Bus) HandleInput(msg device.Message) error {
+	if msg.ID != b.ID() {
+		return nil
+	}
+	_, err := b.Query(msg)
+	return err
+}
+
+// Query answers ["status"] with the bus load and the state of every
+// scheduled transfer, latencies in milliseconds
+func (b *Bus) Query(msg device.Message) ([]interface{}, error) {
+	if len(msg.Values) != 1 || !strings.EqualFold(fmt.Sprint(msg.Values[0]), "status") {
+		return nil, fmt.Errorf("mil1553 %s: unknown command %v", b.ID(), msg.Values)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	transfers := make([]interface{}, len(b.slots))
+	for i, s := range b.slots {
+		mean := 0.0
+		if s.messages > 0 {
+			mean = device.Milliseconds(s.latency) / float64(s.messages)
+		}
+		transfers[i] = map[string]interface{}{
+			"terminal":        s.Terminal,
+			"subaddress":      s.Subaddress,
+			"topic":           s.Topic,
+			"direction":       s.direction(),
+			"queued":          len(s.queue),
+			"transfers":       s.transfers,
+			"messages":        s.messages,
+			"dropped":         s.dropped,
+			"overruns":        s.overruns,
+			"mean_latency_ms": mean,
+			"max_latency_ms":  device.Milliseconds(s.maxLatency),
+		}
+	}
+	return []interface{}{map[string]interface{}{
+		"minor_frame_ms": device.Milliseconds(b.cfg.MinorFrame),
+		"minor_frames":   b.cfg.MinorFrames,
+		"frames":         b.frames,
+		"load":           float64(b.busy) / float64(b.cfg.MinorFrame),
+		"max_load":       float64(b.maxBusy) / float64(b.cfg.MinorFrame),
+		"overruns":       b.overruns,
+		"unscheduled":    b.unscheduled,
+		"transfers":      transfers,
+	}}, nil
+}
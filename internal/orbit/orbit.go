@@ -0,0 +1,149 @@
+package orbit
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	earthRadius   = 6378.137     // km
+	earthMu       = 398600.4418  // km^3/s^2
+	earthRotation = 7.2921159e-5 // rad/s
+	passStep      = 30 * time.Second
+	passPrecision = time.Second
+)
+
+// vec3 is a position in km
+type vec3 struct {
+	x, y, z float64
+}
+
+func (v vec3) sub(o vec3) vec3    { return vec3{v.x - o.x, v.y - o.y, v.z - o.z} }
+func (v vec3) dot(o vec3) float64 { return v.x*o.x + v.y*o.y + v.z*o.z }
+func (v vec3) norm() float64      { return math.Sqrt(v.dot(v)) }
+
+// Orbit represents a circular orbit around a spherical, rotating Earth
+// Angles are in degrees, altitude in km
+type Orbit struct {
+	Altitude    float64
+	Inclination float64
+	RAAN        float64
+	ArgLatitude float64 // argument of latitude at epoch
+	Epoch       time.Time
+}
+
+// Period returns the orbital period
+func (o Orbit) Period() time.Duration {
+	r := earthRadius + o.Altitude
+	return time.Duration(2 * math.Pi * math.Sqrt(r*r*r/earthMu) * float64(time.Second))
+}
+
+// position returns the spacecraft position in Earth-fixed coordinates at t
+func (o Orbit) position(t time.Time) vec3 {
+	r := earthRadius + o.Altitude
+	dt := t.Sub(o.Epoch).Seconds()
+	u := radians(o.ArgLatitude) + math.Sqrt(earthMu/(r*r*r))*dt
+	raan, inc := radians(o.RAAN), radians(o.Inclination)
+
+	// Inertial position
+	x := r * (math.Cos(raan)*math.Cos(u) - math.Sin(raan)*math.Sin(u)*math.Cos(inc))
+	y := r * (math.Sin(raan)*math.Cos(u) + math.Cos(raan)*math.Sin(u)*math.Cos(inc))
+	z := r * math.Sin(u) * math.Sin(inc)
+
+	// Rotate into the Earth-fixed frame, aligned with the inertial frame at epoch
+	theta := earthRotation * dt
+	return vec3{
+		x: x*math.Cos(theta) + y*math.Sin(theta),
+		y: -x*math.Sin(theta) + y*math.Cos(theta),
+		z: z,
+	}
+}
+
+// SubPoint returns the latitude and longitude below the spacecraft in degrees
+func (o Orbit) SubPoint(t time.Time) (lat, lon float64) {
+	p := o.position(t)
+	return degrees(math.Asin(p.z / p.norm())), degrees(math.Atan2(p.y, p.x))
+}
+
+// GroundStation represents a ground antenna
+// Latitude and longitude in degrees, altitude in km, elevation mask in degrees
+type GroundStation struct {
+	ID            string
+	Latitude      float64
+	Longitude     float64
+	Altitude      float64
+	ElevationMask float64
+}
+
+// position returns the station position in Earth-fixed coordinates
+func (g GroundStation) position() vec3 {
+	r := earthRadius + g.Altitude
+	lat, lon := radians(g.Latitude), radians(g.Longitude)
+	return vec3{
+		x: r * math.Cos(lat) * math.Cos(lon),
+		y: r * math.Cos(lat) * math.Sin(lon),
+		z: r * math.Sin(lat),
+	}
+}
+
+// Look returns the spacecraft elevation in degrees and slant range in km
+// as seen from the station at t
+func (g GroundStation) Look(o Orbit, t time.Time) (elevation, rangeKm float64) {
+	station := g.position()
+	los := o.position(t).sub(station)
+	rangeKm = los.norm()
+	elevation = degrees(math.Asin(los.dot(station) / (rangeKm * station.norm())))
+	return elevation, rangeKm
+}
+
+// Visible reports whether the spacecraft is above the station's elevation mask
+func (g GroundStation) Visible(o Orbit, t time.Time) bool {
+	elevation, _ := g.Look(o, t)
+	return elevation >= g.ElevationMask
+}
+
+// NextPass finds the next contact window starting at or after from
+// If the spacecraft is already visible, aos is from. ok is false when no
+// pass starts within horizon, and los is capped at the horizon.
+func (g GroundStation) NextPass(o Orbit, from time.Time, horizon time.Duration) (aos, los time.Time, ok bool) {
+	end := from.Add(horizon)
+
+	aos = from
+	if !g.Visible(o, from) {
+		t := from
+		for !g.Visible(o, t) {
+			if t.After(end) {
+				return time.Time{}, time.Time{}, false
+			}
+			t = t.Add(passStep)
+		}
+		aos = g.edge(o, t.Add(-passStep), t)
+	}
+
+	t := aos
+	for g.Visible(o, t) {
+		if t.After(end) {
+			return aos, end, true
+		}
+		t = t.Add(passStep)
+	}
+	los = g.edge(o, t.Add(-passStep), t)
+	return aos, los, true
+}
+
+// edge bisects the visibility change between a and b
+func (g GroundStation) edge(o Orbit, a, b time.Time) time.Time {
+	visibleA := g.Visible(o, a)
+	for b.Sub(a) > passPrecision {
+		mid := a.Add(b.Sub(a) / 2)
+		if g.Visible(o, mid) == visibleA {
+			a = mid
+		} else {
+			b = mid
+		}
+	}
+	return b
+}
+
+func radians(deg float64) float64 { return deg * math.Pi / 180 }
+func degrees(rad float64) float64 { return rad * 180 / math.Pi }
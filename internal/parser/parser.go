@@ -13,14 +13,26 @@ import (
 type Message struct {
 	ID     string        `json:"id"`
 	Values []interface{} `json:"values"`
+	// At and After time-tag the message for the onboard sequencer in seconds
+	// of simulation time. At is absolute, After is relative to reception.
+	At    *float64 `json:"at,omitempty"`
+	After *float64 `json:"after,omitempty"`
+	// CorrelationID is echoed in the response and in device replies
+	CorrelationID string `json:"correlation_id,omitempty"`
+	// Timeout makes the message a request answered by the device itself,
+	// waiting up to the given seconds for its reply
+	Timeout *float64 `json:"timeout,omitempty"`
 }
 
 // ResponseMessage represents a server response
 type ResponseMessage struct {
-	Type   string      `json:"type"`   // "error" or "success"
-	ID     string      `json:"id"`     // Original message ID
-	Error  string      `json:"error"`  // Error message if any
-	Values interface{} `json:"values"` // Response values if any
+	Type    string      `json:"type"`              // "error", "success", "queued" or "scheduled"
+	ID      string      `json:"id"`                // Original message ID
+	Error   string      `json:"error"`             // Error message if any
+	Values  interface{} `json:"values"`            // Response values if any
+	Command int         `json:"command,omitempty"` // Timed command ID if any
+	// CorrelationID of the message answered, if any
+	CorrelationID string `json:"correlation_id,omitempty"`
 }
 
 // MessageParser defines the interface for parsing messages
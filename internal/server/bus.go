@@ -0,0 +1,121 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"spacecraftsim/internal/bus"
+	"spacecraftsim/internal/device"
+	"spacecraftsim/internal/parser"
+)
+
+// traceBus logs every message published on topics matching the filters
+func (s *Server) traceBus(filters []string) {
+	for _, filter := range filters {
+		_, err := s.ship.AddBusInterceptor(bus.Interceptor{
+			Name:  "trace",
+			Topic: filter,
+			Handle: func(topic string, msg device.Message, next func(device.Message) error) error {
+				log.Printf("Bus %s: %s %v from %s", topic, msg.ID, msg.Values, msg.Source)
+				return next(msg)
+			},
+		})
+		if err != nil {
+			log.Printf("Error tracing bus topic %s: %v", filter, err)
+		}
+	}
+}
+
+// handleBusCommand processes "__bus__" lines returning the depth and drop
+// counters of the bus subscriber queues, none for synchronous delivery
+func (s *Server) handleBusCommand(c *client) {
+	var list []interface{}
+	for _, q := range s.ship.BusQueues() {
+		list = append(list, q)
+	}
+	resp := parser.ResponseMessage{Type: "success", ID: "__bus__", Values: list}
+
+	if err := c.encode(resp); err != nil {
+		log.Printf("Error sending bus response: %v", err)
+	}
+}
+
+// handleValuesCommand processes "__values__" and "__values__ <topic>"
+// lines returning the current value table: the last message of every ID
+// on the retained topics, or on those matching the topic filter
+func (s *Server) handleValuesCommand(c *client, line string) {
+	filter := strings.TrimSpace(strings.TrimPrefix(line, "__values__"))
+	if filter == "" {
+		filter = "#"
+	}
+
+	resp := parser.ResponseMessage{Type: "success", ID: "__values__"}
+	retained, err := s.ship.Retained(filter)
+	if err != nil {
+		resp = parser.ResponseMessage{Type: "error", ID: "__values__", Error: err.Error()}
+	} else {
+		var list []interface{}
+		for _, r := range retained {
+			list = append(list, map[string]interface{}{
+				"topic":  r.Topic,
+				"id":     r.Message.ID,
+				"values": r.Message.Values,
+				"time":   r.Message.Time,
+			})
+		}
+		resp.Values = list
+	}
+
+	if err := c.encode(resp); err != nil {
+		log.Printf("Error sending values response: %v", err)
+	}
+}
+
+// replay feeds a bus recording to the ship
+func (s *Server) replay(path string, realtime bool) {
+	log.Printf("Replaying bus recording %s", path)
+	if err := s.ship.Replay(path, realtime); err != nil {
+		log.Printf("Error replaying bus recording %s: %v", path, err)
+	}
+}
+
+// recordingFile returns the file a bus recording started at a time is
+// written to, the name with the time appended before the extension
+func recordingFile(name string, start time.Time) string {
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + start.Format("-20060102-150405") + ext
+}
+
+// handleRecordCommand processes "__record__ [on|off]" lines switching the
+// bus recording and returning its state
+func (s *Server) handleRecordCommand(c *client, line string) {
+	args := strings.Fields(strings.TrimPrefix(line, "__record__"))
+
+	var err error
+	switch {
+	case len(args) == 0 || args[0] == "status":
+	case args[0] == "on" && len(args) == 1:
+		err = s.ship.RecordBus(recordingFile(s.recording, time.Now()))
+	case args[0] == "off" && len(args) == 1:
+		err = s.ship.StopBusRecording()
+	default:
+		err = fmt.Errorf("unknown record command: %s", strings.Join(args, " "))
+	}
+
+	resp := parser.ResponseMessage{Type: "success", ID: "__record__"}
+	if err != nil {
+		resp = parser.ResponseMessage{Type: "error", ID: "__record__", Error: err.Error()}
+	} else {
+		file := s.ship.BusRecording()
+		resp.Values = []interface{}{map[string]interface{}{
+			"recording": file != "",
+			"file":      file,
+		}}
+	}
+	if err := c.encode(resp); err != nil {
+		log.Printf("Error sending record response: %v", err)
+	}
+}
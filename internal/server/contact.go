@@ -0,0 +1,251 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"spacecraftsim/internal/config"
+	"spacecraftsim/internal/orbit"
+	"spacecraftsim/internal/parser"
+)
+
+const (
+	// contactCheckInterval is how often ground station visibility is updated
+	contactCheckInterval = time.Second
+	// passSearchHorizon bounds the search for the next pass
+	passSearchHorizon = 24 * time.Hour
+	// maxHeldTelemetry bounds the telemetry stored for a client between passes
+	maxHeldTelemetry = 4096
+	// maxPendingCommands bounds the commands queued for a client between
+	// passes, further commands are rejected
+	maxPendingCommands = 256
+)
+
+// command is a client command, a message or an uplink line, waiting for
+// contact
+type command struct {
+	msg  parser.Message
+	line string // uplink line such as "__sequence__ ...", empty for messages
+}
+
+// isUplinkLine reports whether a line is a special command that changes
+// the spacecraft and so needs contact: "__sequence__" and "__record__"
+func isUplinkLine(line string) bool {
+	return strings.HasPrefix(line, "__sequence__") || strings.HasPrefix(line, "__record__")
+}
+
+// uplink executes an uplink line
+func (s *Server) uplink(c *client, line string) {
+	if strings.HasPrefix(line, "__sequence__") {
+		s.handleSequenceCommand(c, line)
+	} else {
+		s.handleRecordCommand(c, line)
+	}
+}
+
+// groundStation tracks contact between a ground station and the spacecraft
+type groundStation struct {
+	orbit.GroundStation
+	policy  config.ContactPolicy
+	visible bool
+}
+
+// setupContacts creates the configured ground stations
+func (s *Server) setupContacts(cfg *config.Config) {
+	s.orbit = orbit.Orbit{
+		Altitude:    cfg.Orbit.Altitude,
+		Inclination: cfg.Orbit.Inclination,
+		RAAN:        cfg.Orbit.RAAN,
+		ArgLatitude: cfg.Orbit.ArgLatitude,
+		Epoch:       time.Now(),
+	}
+	s.defaultStation = cfg.DefaultStation
+
+	for _, gs := range cfg.GroundStations {
+		station := &groundStation{
+			GroundStation: orbit.GroundStation{
+				ID:            gs.ID,
+				Latitude:      gs.Latitude,
+				Longitude:     gs.Longitude,
+				Altitude:      gs.Altitude,
+				ElevationMask: gs.ElevationMask,
+			},
+			policy: gs.OutsideAOS,
+		}
+		station.visible = station.Visible(s.orbit, time.Now())
+		s.stations[gs.ID] = station
+	}
+
+	if len(s.stations) > 0 {
+		go s.trackContacts()
+	}
+}
+
+// trackContacts updates station visibility and releases held traffic on AOS
+func (s *Server) trackContacts() {
+	ticker := time.NewTicker(contactCheckInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		var acquired []*client
+		var lost []*groundStation
+
+		s.mu.Lock()
+		for _, station := range s.stations {
+			visible := station.Visible(s.orbit, now)
+			if visible == station.visible {
+				continue
+			}
+			station.visible = visible
+			if !visible {
+				lost = append(lost, station)
+				continue
+			}
+			_, rangeKm := station.Look(s.orbit, now)
+			log.Printf("AOS at ground station %s, range %.0f km", station.ID, rangeKm)
+			for _, c := range s.clients {
+				if c.station == station {
+					acquired = append(acquired, c)
+				}
+			}
+		}
+		s.mu.Unlock()
+
+		// The pass search takes a while, do it without the lock
+		for _, station := range lost {
+			log.Printf("LOS at ground station %s%s", station.ID, s.nextAOSText(station, now))
+		}
+		for _, c := range acquired {
+			s.releaseHeld(c)
+		}
+	}
+}
+
+// nextAOSText describes the next pass over a station for log and error messages
+// It searches up to passSearchHorizon ahead, callers must not hold s.mu
+func (s *Server) nextAOSText(station *groundStation, now time.Time) string {
+	aos, _, ok := station.NextPass(s.orbit, now, passSearchHorizon)
+	if !ok {
+		return ", no pass in the next 24h"
+	}
+	return fmt.Sprintf(", next AOS at %s", aos.Format(time.RFC3339))
+}
+
+// inContact reports whether the client currently has a link to the spacecraft
+// The caller must hold s.mu
+func (s *Server) inContact(c *client) bool {
+	return c.station == nil || c.station.visible
+}
+
+// setStation ties a client to a ground station, or unties it when id is empty
+func (s *Server) setStation(c *client, id string) error {
+	s.mu.Lock()
+	if id == "" {
+		c.station = nil
+	} else {
+		station, exists := s.stations[id]
+		if !exists {
+			s.mu.Unlock()
+			return fmt.Errorf("unknown ground station: %s", id)
+		}
+		c.station = station
+	}
+	s.mu.Unlock()
+
+	// The new station may already be in contact
+	s.releaseHeld(c)
+	return nil
+}
+
+// handleStationCommand processes a "__station__ <id>" line
+func (s *Server) handleStationCommand(c *client, line string) {
+	id := strings.TrimSpace(strings.TrimPrefix(line, "__station__"))
+	resp := parser.ResponseMessage{Type: "success", ID: "__station__", Values: []interface{}{id}}
+	if err := s.setStation(c, id); err != nil {
+		resp = parser.ResponseMessage{Type: "error", ID: "__station__", Error: err.Error()}
+	}
+	if err := c.encode(resp); err != nil {
+		log.Printf("Error sending station response: %v", err)
+	}
+}
+
+// admit decides whether a client command can be executed now
+// Outside a contact window the command is queued or rejected according to
+// the station policy, and the client is told which. Commands are rejected
+// once maxPendingCommands are queued.
+func (s *Server) admit(c *client, cmd command) bool {
+	msg := cmd.msg
+	s.mu.Lock()
+	if s.inContact(c) {
+		s.mu.Unlock()
+		return true
+	}
+	station := c.station
+	s.mu.Unlock()
+
+	next := s.nextAOSText(station, time.Now())
+	resp := parser.ResponseMessage{ID: msg.ID, CorrelationID: msg.CorrelationID}
+
+	s.mu.Lock()
+	switch {
+	case s.inContact(c):
+		// Contact was acquired during the pass search
+		s.mu.Unlock()
+		return true
+	case station.policy == config.PolicyReject:
+		resp.Type = "error"
+		resp.Error = fmt.Sprintf("No contact with ground station %s%s", station.ID, next)
+	case len(c.pending) >= maxPendingCommands:
+		resp.Type = "error"
+		resp.Error = fmt.Sprintf("Too many commands queued for ground station %s%s", station.ID, next)
+	default:
+		c.pending = append(c.pending, cmd)
+		resp.Type = "queued"
+		resp.Values = msg.Values
+		resp.Error = fmt.Sprintf("Queued until AOS at ground station %s%s", station.ID, next)
+	}
+	s.mu.Unlock()
+
+	if err := c.encode(resp); err != nil {
+		log.Printf("Error sending response: %v", err)
+	}
+	return false
+}
+
+// hold stores telemetry for a client outside its contact window
+// The caller must hold s.mu
+func (s *Server) hold(c *client, data []byte) {
+	if len(c.held) >= maxHeldTelemetry {
+		c.held = c.held[1:]
+	}
+	c.held = append(c.held, data)
+}
+
+// releaseHeld sends telemetry held for a client and executes its queued
+// commands once it is in contact
+func (s *Server) releaseHeld(c *client) {
+	s.mu.Lock()
+	if !s.inContact(c) {
+		s.mu.Unlock()
+		return
+	}
+	held, pending := c.held, c.pending
+	c.held, c.pending = nil, nil
+	s.mu.Unlock()
+
+	for _, data := range held {
+		if err := c.write(data); err != nil {
+			log.Printf("Error sending held telemetry to %s: %v", c.source, err)
+			return
+		}
+	}
+	for _, cmd := range pending {
+		if cmd.line != "" {
+			s.uplink(c, cmd.line)
+		} else {
+			s.dispatch(c, cmd.msg)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"fmt"
+
+	"spacecraftsim/internal/config"
+	"spacecraftsim/internal/device"
+)
+
+// buildDevice creates a device from its configuration
+func buildDevice(cfg config.DeviceConfig) (device.Device, error) {
+	switch cfg.Type {
+	case config.TypePID:
+		p := cfg.PID
+		return device.NewPID(cfg.ID, device.PIDConfig{
+			Measurement:  signal(p.Measurement),
+			Output:       signal(p.Output),
+			Setpoint:     p.Setpoint,
+			Kp:           p.Kp,
+			Ki:           p.Ki,
+			Kd:           p.Kd,
+			OutputMin:    p.OutputMin,
+			OutputMax:    p.OutputMax,
+			RateLimit:    p.RateLimit,
+			AntiWindup:   p.AntiWindup,
+			TrackingGain: p.TrackingGain,
+			TickRate:     cfg.TickRate,
+		})
+	case config.TypeSensor:
+		return buildSensor(cfg)
+	case config.TypeEcho:
+		e := device.NewEcho(cfg.ID)
+		if ec := cfg.Echo; ec != nil {
+			err := e.Configure(device.EchoConfig{
+				Delay:           ec.Delay,
+				Transform:       ec.Transform,
+				Scale:           ec.Scale,
+				LossProbability: ec.LossProbability,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		return e, nil
+	case config.TypeRecorder:
+		return device.NewRecorder(cfg.ID, cfg.Recorder.Capacity, cfg.Recorder.Topics...), nil
+	case config.TypeStateMachine:
+		return buildStateMachine(cfg)
+	case config.TypeSwitch:
+		on := true
+		if cfg.Switch != nil && cfg.Switch.On != nil {
+			on = *cfg.Switch.On
+		}
+		return device.NewSwitch(cfg.ID, on), nil
+	default:
+		return nil, fmt.Errorf("unknown device type %s", cfg.Type)
+	}
+}
+
+// buildSensor creates a sensor with its truth source and error models
+func buildSensor(cfg config.DeviceConfig) (device.Device, error) {
+	sc := cfg.Sensor
+	s := device.NewSensor(cfg.ID, sc.Initial, sc.Noise)
+	if cfg.TickRate > 0 {
+		s.SetTickRate(cfg.TickRate)
+	}
+	if sc.Truth != nil {
+		s.SetTruthSource(sc.Truth.Topic, sc.Truth.ID)
+	}
+	if sc.Topic != "" {
+		s.SetTopic(sc.Topic)
+	}
+	if p := sc.Publish; p != nil {
+		deadband, heartbeat := sc.Noise/2, device.DefaultHeartbeat
+		if p.Deadband != nil {
+			deadband = *p.Deadband
+		}
+		if p.Heartbeat != nil {
+			heartbeat = *p.Heartbeat
+		}
+		if err := s.SetPublishPolicy(p.Policy, p.Period, deadband, heartbeat); err != nil {
+			return nil, err
+		}
+	}
+	for _, m := range sc.Errors {
+		model, err := buildErrorModel(m)
+		if err != nil {
+			return nil, err
+		}
+		s.AddErrorModel(model)
+	}
+	return s, nil
+}
+
+// buildStateMachine creates a state machine from its states and transitions
+func buildStateMachine(cfg config.DeviceConfig) (device.Device, error) {
+	smc := cfg.StateMachine
+	sm := device.StateMachineConfig{
+		Initial:  smc.Initial,
+		Watch:    smc.Watch,
+		TickRate: cfg.TickRate,
+	}
+	for _, st := range smc.States {
+		sm.States = append(sm.States, buildState(st))
+	}
+	transitions, err := buildTransitions(smc.Transitions)
+	if err != nil {
+		return nil, fmt.Errorf("state machine %s: %w", cfg.ID, err)
+	}
+	sm.Transitions = transitions
+	return device.NewStateMachine(cfg.ID, sm)
+}
+
+// buildState creates a state with its entry and exit actions
+func buildState(cfg config.StateConfig) device.State {
+	state := device.State{Name: cfg.Name, Timeout: cfg.Timeout, TimeoutTo: cfg.TimeoutTo}
+	for _, action := range cfg.OnEntry {
+		state.OnEntry = append(state.OnEntry, signal(action))
+	}
+	for _, action := range cfg.OnExit {
+		state.OnExit = append(state.OnExit, signal(action))
+	}
+	return state
+}
+
+// buildTransitions creates transitions and parses their guards
+func buildTransitions(cfg []config.TransitionConfig) ([]device.Transition, error) {
+	var transitions []device.Transition
+	for _, tr := range cfg {
+		transition := device.Transition{From: tr.From, To: tr.To, Auto: tr.Auto}
+		for _, expr := range tr.Guards {
+			guard, err := device.ParseCondition(expr)
+			if err != nil {
+				return nil, err
+			}
+			transition.Guards = append(transition.Guards, guard)
+		}
+		transitions = append(transitions, transition)
+	}
+	return transitions, nil
+}
+
+// buildErrorModel creates a sensor error model from its configuration
+func buildErrorModel(cfg config.ErrorModelConfig) (device.ErrorModel, error) {
+	switch cfg.Type {
+	case "gaussian":
+		return &device.GaussianNoise{Sigma: cfg.Sigma}, nil
+	case "bias":
+		return &device.Bias{Offset: cfg.Offset}, nil
+	case "drift":
+		return &device.BiasDrift{Rate: cfg.Rate, RandomWalk: cfg.RandomWalk}, nil
+	case "scale":
+		return &device.ScaleFactor{Factor: cfg.Factor}, nil
+	case "quantize":
+		return &device.Quantization{Step: cfg.Step}, nil
+	case "saturate":
+		return &device.Saturation{Min: cfg.Min, Max: cfg.Max}, nil
+	case "latency":
+		return &device.Latency{Delay: cfg.Delay}, nil
+	case "sample_hold":
+		return &device.SampleHold{Period: cfg.Period}, nil
+	default:
+		return nil, fmt.Errorf("unknown error model %s", cfg.Type)
+	}
+}
+
+// signal converts a configured signal to a device signal
+func signal(cfg config.SignalConfig) device.Signal {
+	return device.Signal{Topic: cfg.Topic, ID: cfg.ID, Values: cfg.Values}
+}
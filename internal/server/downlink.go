@@ -0,0 +1,176 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"spacecraftsim/internal/device"
+	"spacecraftsim/internal/parser"
+)
+
+const (
+	// downlinkQueueSize bounds the telemetry waiting for link capacity
+	downlinkQueueSize = 256
+	speedOfLight      = 299792.458 // km/s
+)
+
+// frame is a serialized telemetry message waiting to be downlinked
+type frame struct {
+	data     []byte
+	queuedAt time.Time
+}
+
+// Downlink forwards bus telemetry to clients through the simulated
+// space-ground link. Telemetry is dropped while the comms device reports
+// no lock, throttled to the reported bandwidth and delayed by light time.
+type Downlink struct {
+	*device.BaseDevice
+	mu        sync.Mutex
+	commsID   string
+	locked    bool
+	bandwidth float64 // bps, 0 means unthrottled
+	delay     time.Duration
+	dropped   int
+	queue     chan frame
+	send      func(data []byte)
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+// NewDownlink creates a downlink that forwards the given topics and follows
+// the link state published by the comms device commsID
+func NewDownlink(id, commsID string, send func(data []byte), topics ...string) *Downlink {
+	d := &Downlink{
+		BaseDevice: device.NewBaseDevice(id, 0),
+		commsID:    commsID,
+		locked:     true,
+		queue:      make(chan frame, downlinkQueueSize),
+		send:       send,
+		stop:       make(chan struct{}),
+	}
+	d.AddTopic("comms")
+	for _, topic := range topics {
+		d.AddTopic(topic)
+	}
+	go d.run()
+	return d
+}
+
+// Subscribe registers the downlink to receive telemetry on its topics
+func (d *Downlink) Subscribe(bus device.Bus) error {
+	return d.SubscribeAs(bus, d)
+}
+
+// HandleInput queues telemetry for the ground and tracks the link state
+func (d *Downlink) HandleInput(msg device.Message) error {
+	if msg.ID == d.ID() {
+		return fmt.Errorf("downlink %s does not accept commands", d.ID())
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if strings.HasPrefix(msg.ID, d.commsID+".") && len(msg.Values) > 0 {
+		d.updateLink(strings.TrimPrefix(msg.ID, d.commsID+"."), msg.Values[0])
+	}
+
+	if !d.locked {
+		d.dropped++
+		return nil
+	}
+
+	data, err := json.Marshal([]parser.Message{{ID: msg.ID, Values: msg.Values}})
+	if err != nil {
+		return fmt.Errorf("failed to serialize telemetry: %w", err)
+	}
+	select {
+	case d.queue <- frame{data: append(data, '\n'), queuedAt: time.Now()}:
+	default:
+		// The link cannot keep up with the telemetry rate
+		d.dropped++
+	}
+	return nil
+}
+
+// updateLink applies a comms telemetry parameter to the link state
+func (d *Downlink) updateLink(param string, value interface{}) {
+	switch param {
+	case "lock":
+		if locked, ok := value.(bool); ok {
+			d.locked = locked
+		}
+	case "bandwidth":
+		if bps, ok := value.(float64); ok {
+			d.bandwidth = bps
+		}
+	case "range":
+		if km, ok := value.(float64); ok {
+			d.delay = time.Duration(km / speedOfLight * float64(time.Second))
+		}
+	}
+}
+
+// Dropped returns the number of telemetry messages lost on the link
+func (d *Downlink) Dropped() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dropped
+}
+
+// Stop stops sending telemetry, queued frames are dropped
+func (d *Downlink) Stop() {
+	d.stopOnce.Do(func() { close(d.stop) })
+}
+
+// run sends queued frames at the link rate until the downlink is stopped
+func (d *Downlink) run() {
+	for {
+		var f frame
+		select {
+		case <-d.stop:
+			return
+		case f = <-d.queue:
+		}
+
+		d.mu.Lock()
+		locked, bandwidth, delay := d.locked, d.bandwidth, d.delay
+		if !locked {
+			// Loss of signal while the frame was waiting
+			d.dropped++
+		}
+		d.mu.Unlock()
+		if !locked {
+			continue
+		}
+
+		wait := time.Until(f.queuedAt.Add(delay))
+		if wait < 0 {
+			wait = 0
+		}
+		if bandwidth > 0 {
+			wait += time.Duration(float64(len(f.data)*8) / bandwidth * float64(time.Second))
+		}
+		if !d.sleep(wait) {
+			return
+		}
+		d.send(f.data)
+	}
+}
+
+// sleep waits for a duration, false if the downlink was stopped meanwhile
+func (d *Downlink) sleep(wait time.Duration) bool {
+	if wait <= 0 {
+		return true
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-d.stop:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"spacecraftsim/internal/config"
+	"spacecraftsim/internal/device"
+	"spacecraftsim/internal/fdir"
+	"spacecraftsim/internal/parser"
+)
+
+// registerFDIR adds the FDIR engine running the configured rules
+func (s *Server) registerFDIR(rules []config.FDIRRuleConfig) error {
+	var built []fdir.Rule
+	for _, r := range rules {
+		monitor, err := device.ParseCondition(r.Monitor)
+		if err != nil {
+			return fmt.Errorf("fdir rule %s: %w", r.ID, err)
+		}
+		built = append(built, fdir.Rule{
+			ID:          r.ID,
+			Topic:       r.Topic,
+			Monitor:     monitor,
+			Persistence: r.Persistence,
+			Isolation:   actions(r.Isolation),
+			Recovery:    actions(r.Recovery),
+		})
+	}
+
+	engine, err := fdir.NewEngine("fdir", s.ship, built)
+	if err != nil {
+		return err
+	}
+	return s.ship.RegisterDevice(engine)
+}
+
+// actions converts configured FDIR actions
+func actions(cfg []config.ActionConfig) []fdir.Action {
+	out := make([]fdir.Action, len(cfg))
+	for i, a := range cfg {
+		out[i] = fdir.Action{ID: a.ID, Values: a.Values, After: a.After}
+	}
+	return out
+}
+
+// handleEventsCommand processes "__events__" and "__events__ <n>" lines
+// returning all kept events or the last n
+func (s *Server) handleEventsCommand(c *client, line string) {
+	args := strings.Fields(strings.TrimPrefix(line, "__events__"))
+	resp := parser.ResponseMessage{Type: "success", ID: "__events__"}
+
+	n := 0
+	var err error
+	if len(args) == 1 {
+		n, err = strconv.Atoi(args[0])
+	} else if len(args) > 1 {
+		err = fmt.Errorf("unknown events command: %s", strings.Join(args, " "))
+	}
+
+	if err != nil {
+		resp = parser.ResponseMessage{Type: "error", ID: "__events__", Error: err.Error()}
+	} else {
+		var list []interface{}
+		for _, e := range s.ship.Events(n) {
+			list = append(list, map[string]interface{}{
+				"time":   e.Time.Seconds(),
+				"id":     e.ID,
+				"values": e.Values,
+			})
+		}
+		resp.Values = list
+	}
+
+	if err := c.encode(resp); err != nil {
+		log.Printf("Error sending events response: %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"fmt"
+	"log"
+
+	"spacecraftsim/internal/can"
+	"spacecraftsim/internal/config"
+	"spacecraftsim/internal/device"
+	"spacecraftsim/internal/mil1553"
+)
+
+// attachment is a point of an emulated bus and the devices behind it
+type attachment struct {
+	name    string     // e.g. "terminal 1" or "node o2_node"
+	bus     device.Bus // nil when only checking the devices
+	devices []string
+}
+
+// attach connects the devices behind the points of an emulated bus,
+// before they are registered, and registers the bus device
+func (s *Server) attach(name string, dev device.Device, points []attachment) error {
+	for _, p := range points {
+		for _, id := range p.devices {
+			if err := s.ship.SetDeviceBus(id, p.bus); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return s.ship.RegisterDevice(dev)
+}
+
+// checkAttached reports devices behind an emulated bus that were never
+// registered
+func (s *Server) checkAttached(name string, points []attachment) {
+	for _, p := range points {
+		for _, id := range p.devices {
+			if _, err := s.ship.Health(id); err != nil {
+				log.Printf("%s: %s device %s: %v", name, p.name, id, err)
+			}
+		}
+	}
+}
+
+// setupAvionicsBus registers the avionics bus controller and connects the
+// devices behind its terminals
+func (s *Server) setupAvionicsBus(cfg *config.AvionicsBusConfig) error {
+	busCfg := mil1553.Config{
+		MinorFrame:   cfg.MinorFrame,
+		MinorFrames:  cfg.MinorFrames,
+		WordTime:     cfg.WordTime,
+		ResponseTime: cfg.ResponseTime,
+		Gap:          cfg.Gap,
+		QueueSize:    cfg.QueueSize,
+	}
+	for _, t := range cfg.Schedule {
+		busCfg.Schedule = append(busCfg.Schedule, mil1553.Transfer{
+			Terminal:   t.Terminal,
+			Subaddress: t.Subaddress,
+			Topic:      t.Topic,
+			Transmit:   t.Transmit,
+			Frames:     t.Frames,
+		})
+	}
+
+	controller, err := mil1553.New(cfg.ID, s.ship.Bus(), busCfg)
+	if err != nil {
+		return err
+	}
+	var points []attachment
+	for _, t := range cfg.Terminals {
+		terminal, err := controller.Terminal(t.Address)
+		if err != nil {
+			return err
+		}
+		points = append(points, attachment{name: fmt.Sprintf("terminal %d", t.Address), bus: terminal, devices: t.Devices})
+	}
+	return s.attach("avionics bus "+cfg.ID, controller, points)
+}
+
+// checkTerminals reports terminal devices that were never registered
+func (s *Server) checkTerminals(cfg *config.AvionicsBusConfig) {
+	var points []attachment
+	for _, t := range cfg.Terminals {
+		points = append(points, attachment{name: fmt.Sprintf("terminal %d", t.Address), devices: t.Devices})
+	}
+	s.checkAttached("Avionics bus "+cfg.ID, points)
+}
+
+// setupCANBus registers a CAN segment and connects the devices behind its
+// nodes
+func (s *Server) setupCANBus(cfg *config.CANBusConfig) error {
+	segCfg := can.Config{
+		Bitrate:     cfg.Bitrate,
+		FD:          cfg.FD,
+		DataBitrate: cfg.DataBitrate,
+		QueueSize:   cfg.QueueSize,
+		AutoRecover: cfg.AutoRecover,
+		Gateway:     canFrames(cfg.Gateway),
+	}
+	for _, n := range cfg.Nodes {
+		segCfg.Nodes = append(segCfg.Nodes, can.NodeConfig{
+			Name:      n.Name,
+			Devices:   n.Devices,
+			Frames:    canFrames(n.Frames),
+			ErrorRate: n.ErrorRate,
+		})
+	}
+
+	segment, err := can.New(cfg.ID, s.ship.Bus(), segCfg)
+	if err != nil {
+		return err
+	}
+	var points []attachment
+	for _, n := range cfg.Nodes {
+		nodeBus, err := segment.Node(n.Name)
+		if err != nil {
+			return err
+		}
+		points = append(points, attachment{name: "node " + n.Name, bus: nodeBus, devices: n.Devices})
+	}
+	return s.attach("CAN bus "+cfg.ID, segment, points)
+}
+
+// canFrames converts configured frames
+func canFrames(frames []config.CANFrameConfig) []can.Frame {
+	var out []can.Frame
+	for _, f := range frames {
+		out = append(out, can.Frame{Topic: f.Topic, ID: f.ID, Extended: f.Extended})
+	}
+	return out
+}
+
+// checkCANNodes reports node devices that were never registered
+func (s *Server) checkCANNodes(cfg *config.CANBusConfig) {
+	var points []attachment
+	for _, n := range cfg.Nodes {
+		points = append(points, attachment{name: "node " + n.Name, devices: n.Devices})
+	}
+	s.checkAttached("CAN bus "+cfg.ID, points)
+}
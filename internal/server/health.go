@@ -0,0 +1,76 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"spacecraftsim/internal/config"
+	"spacecraftsim/internal/parser"
+	"spacecraftsim/internal/ship"
+)
+
+// healthThresholds applies configured thresholds over base
+func healthThresholds(base ship.HealthThresholds, cfg *config.HealthConfig) ship.HealthThresholds {
+	if cfg == nil {
+		return base
+	}
+	if cfg.DegradedAfter != nil {
+		base.Degraded = *cfg.DegradedAfter
+	}
+	if cfg.FailedAfter != nil {
+		base.Failed = *cfg.FailedAfter
+	}
+	return base
+}
+
+// handleHealthCommand processes "__health__", "__health__ <device>" and
+// "__health__ reset <device>" lines
+func (s *Server) handleHealthCommand(c *client, line string) {
+	args := strings.Fields(strings.TrimPrefix(line, "__health__"))
+	resp := parser.ResponseMessage{Type: "success", ID: "__health__"}
+
+	switch {
+	case len(args) == 0:
+		all, overall := s.ship.HealthReport()
+		ids := make([]string, 0, len(all))
+		for id := range all {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		list := []interface{}{map[string]interface{}{"device": "ship", "status": overall}}
+		for _, id := range ids {
+			h := all[id]
+			list = append(list, map[string]interface{}{
+				"device": id,
+				"status": h.Status,
+				"code":   h.Code,
+				"reason": h.Reason,
+				"cause":  h.Cause,
+				"since":  h.Since,
+			})
+		}
+		resp.Values = list
+	case args[0] == "reset" && len(args) == 2:
+		if err := s.ship.ResetHealth(args[1]); err != nil {
+			resp = parser.ResponseMessage{Type: "error", ID: "__health__", Error: err.Error()}
+		} else {
+			resp.Values = []interface{}{args[1]}
+		}
+	case len(args) == 1:
+		h, err := s.ship.Health(args[0])
+		if err != nil {
+			resp = parser.ResponseMessage{Type: "error", ID: "__health__", Error: err.Error()}
+		} else {
+			resp.Values = []interface{}{h}
+		}
+	default:
+		resp = parser.ResponseMessage{Type: "error", ID: "__health__", Error: fmt.Sprintf("unknown health command: %s", strings.Join(args, " "))}
+	}
+
+	if err := c.encode(resp); err != nil {
+		log.Printf("Error sending health response: %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"spacecraftsim/internal/device"
+	"spacecraftsim/internal/parser"
+	"spacecraftsim/internal/ship"
+)
+
+const (
+	// metricsPrefix prefixes the names of the exported metrics
+	metricsPrefix = "spacecraftsim_"
+	// metricsShutdownTimeout bounds the wait for metrics requests in
+	// flight when the server stops
+	metricsShutdownTimeout = time.Second
+)
+
+// newMetricsServer creates the HTTP server exposing the runtime metrics in
+// the Prometheus text format on /metrics
+func (s *Server) newMetricsServer(address string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := s.writeMetrics(w); err != nil {
+			log.Printf("Error writing metrics: %v", err)
+		}
+	})
+	return &http.Server{Addr: address, Handler: mux}
+}
+
+// serveMetrics serves the metrics until the server stops
+func (s *Server) serveMetrics() {
+	log.Printf("Serving metrics on %s/metrics", s.metrics.Addr)
+	if err := s.metrics.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("Metrics server error: %v", err)
+	}
+}
+
+// writeMetrics writes the bus, device and ship loop metrics in the
+// Prometheus text format
+func (s *Server) writeMetrics(out io.Writer) error {
+	w := bufio.NewWriter(out)
+
+	topics := s.ship.BusTopics()
+	family(w, "bus_messages_total", "counter", "Messages published on a topic")
+	for _, t := range topics {
+		sample(w, "bus_messages_total", labels("topic", t.Topic), float64(t.Messages))
+	}
+	family(w, "bus_bytes_total", "counter", "Bytes published on a topic, estimated from the message fields")
+	for _, t := range topics {
+		sample(w, "bus_bytes_total", labels("topic", t.Topic), float64(t.Bytes))
+	}
+	family(w, "bus_message_rate", "gauge", "Messages per second on a topic over the last 10s window")
+	for _, t := range topics {
+		sample(w, "bus_message_rate", labels("topic", t.Topic), t.Rate)
+	}
+	family(w, "bus_message_max_bytes", "gauge", "Largest message published on a topic")
+	for _, t := range topics {
+		sample(w, "bus_message_max_bytes", labels("topic", t.Topic), float64(t.MaxSize))
+	}
+	family(w, "bus_topic_subscribers", "gauge", "Devices the last message on a topic was sent to")
+	for _, t := range topics {
+		sample(w, "bus_topic_subscribers", labels("topic", t.Topic), float64(t.Subscribers))
+	}
+
+	subscriptions := s.ship.BusSubscriptions()
+	filters := make([]string, 0, len(subscriptions))
+	for filter := range subscriptions {
+		filters = append(filters, filter)
+	}
+	sort.Strings(filters)
+	family(w, "bus_subscriptions", "gauge", "Subscribers of a topic filter")
+	for _, filter := range filters {
+		sample(w, "bus_subscriptions", labels("filter", filter), float64(subscriptions[filter]))
+	}
+
+	if queues := s.ship.BusQueues(); queues != nil {
+		family(w, "bus_queue_depth", "gauge", "Messages waiting in the queue of a subscriber")
+		for _, q := range queues {
+			sample(w, "bus_queue_depth", labels("device", q.Device), float64(q.Depth))
+		}
+		family(w, "bus_queue_dropped_total", "counter", "Messages dropped from the queue of a subscriber")
+		for _, q := range queues {
+			sample(w, "bus_queue_dropped_total", labels("device", q.Device), float64(q.Dropped))
+		}
+	}
+
+	devices := s.ship.DeviceMetrics()
+	family(w, "device_input_seconds", "histogram", "Time a device spent handling a message")
+	for _, d := range devices {
+		histogram(w, "device_input_seconds", labels("device", d.Device), d.Inputs)
+	}
+	family(w, "device_input_errors_total", "counter", "Messages a device failed to handle")
+	for _, d := range devices {
+		sample(w, "device_input_errors_total", labels("device", d.Device), float64(d.InputErrors))
+	}
+	family(w, "device_tick_seconds", "histogram", "Time a device spent in a tick")
+	for _, d := range devices {
+		histogram(w, "device_tick_seconds", labels("device", d.Device), d.Ticks)
+	}
+	family(w, "device_tick_errors_total", "counter", "Ticks that returned an error")
+	for _, d := range devices {
+		sample(w, "device_tick_errors_total", labels("device", d.Device), float64(d.TickErrors))
+	}
+	family(w, "device_tick_overruns_total", "counter", "Ticks that took longer than the tick rate of the device")
+	for _, d := range devices {
+		sample(w, "device_tick_overruns_total", labels("device", d.Device), float64(d.TickOverruns))
+	}
+
+	loop := s.ship.LoopMetrics()
+	family(w, "loop_step_seconds", "histogram", "Time a step of the ship loop took")
+	histogram(w, "loop_step_seconds", "", loop.Steps)
+	family(w, "loop_overruns_total", "counter", "Steps of the ship loop that took longer than the base tick")
+	sample(w, "loop_overruns_total", "", float64(loop.Overruns))
+
+	return w.Flush()
+}
+
+// family writes the help and type lines of a metric
+func family(w *bufio.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s%s %s\n# TYPE %s%s %s\n", metricsPrefix, name, help, metricsPrefix, name, kind)
+}
+
+// sample writes a sample of a metric with its formatted labels
+func sample(w *bufio.Writer, name, labels string, value float64) {
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s%s%s %g\n", metricsPrefix, name, labels, value)
+}
+
+// histogram writes the cumulative buckets, sum and count of a histogram
+func histogram(w *bufio.Writer, name, labels string, h ship.Histogram) {
+	join := func(le string) string {
+		if labels == "" {
+			return `le="` + le + `"`
+		}
+		return labels + `,le="` + le + `"`
+	}
+
+	var cumulative uint64
+	for i, bound := range ship.LatencyBuckets {
+		cumulative += h.Counts[i]
+		sample(w, name+"_bucket", join(fmt.Sprint(bound.Seconds())), float64(cumulative))
+	}
+	sample(w, name+"_bucket", join("+Inf"), float64(h.Count))
+	sample(w, name+"_sum", labels, h.Sum.Seconds())
+	sample(w, name+"_count", labels, float64(h.Count))
+}
+
+// labels formats a label pair with its value escaped
+func labels(name, value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return name + `="` + value + `"`
+}
+
+// handleMetricsCommand processes "__metrics__" and "__metrics__ <section>"
+// lines returning the runtime metrics: the traffic of the bus topics, the
+// subscribers of the topic filters, the device latencies and errors and
+// the ship loop steps
+// Sections are topics, subscriptions, devices and loop; latencies are in
+// milliseconds.
+func (s *Server) handleMetricsCommand(c *client, line string) {
+	args := strings.Fields(strings.TrimPrefix(line, "__metrics__"))
+
+	sections := map[string]func() interface{}{
+		"topics": func() interface{} {
+			list := []interface{}{}
+			for _, t := range s.ship.BusTopics() {
+				list = append(list, t)
+			}
+			return list
+		},
+		"subscriptions": func() interface{} {
+			return s.ship.BusSubscriptions()
+		},
+		"devices": func() interface{} {
+			list := []interface{}{}
+			for _, d := range s.ship.DeviceMetrics() {
+				list = append(list, map[string]interface{}{
+					"device":        d.Device,
+					"inputs":        latencies(d.Inputs),
+					"input_errors":  d.InputErrors,
+					"ticks":         latencies(d.Ticks),
+					"tick_errors":   d.TickErrors,
+					"tick_overruns": d.TickOverruns,
+				})
+			}
+			return list
+		},
+		"loop": func() interface{} {
+			loop := s.ship.LoopMetrics()
+			return map[string]interface{}{
+				"steps":    latencies(loop.Steps),
+				"overruns": loop.Overruns,
+			}
+		},
+	}
+
+	resp := parser.ResponseMessage{Type: "success", ID: "__metrics__"}
+	switch {
+	case len(args) == 0:
+		all := make(map[string]interface{}, len(sections))
+		for name, section := range sections {
+			all[name] = section()
+		}
+		resp.Values = []interface{}{all}
+	case len(args) == 1 && sections[args[0]] != nil:
+		resp.Values = []interface{}{sections[args[0]]()}
+	default:
+		resp = parser.ResponseMessage{Type: "error", ID: "__metrics__", Error: fmt.Sprintf("unknown metrics section: %s", strings.Join(args, " "))}
+	}
+
+	if err := c.encode(resp); err != nil {
+		log.Printf("Error sending metrics response: %v", err)
+	}
+}
+
+// latencies summarizes a histogram in milliseconds
+func latencies(h ship.Histogram) map[string]interface{} {
+	return map[string]interface{}{
+		"count":   h.Count,
+		"mean_ms": device.Milliseconds(h.Mean()),
+		"max_ms":  device.Milliseconds(h.Max),
+	}
+}
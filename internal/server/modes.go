@@ -0,0 +1,31 @@
+package server
+
+import (
+	"fmt"
+
+	"spacecraftsim/internal/config"
+	"spacecraftsim/internal/ship"
+)
+
+// registerModes enables the configured ship modes
+func (s *Server) registerModes(cfg *config.ModesConfig) error {
+	transitions, err := buildTransitions(cfg.Transitions)
+	if err != nil {
+		return fmt.Errorf("modes: %w", err)
+	}
+
+	modes := ship.ModeConfig{
+		Initial:     cfg.Initial,
+		Transitions: transitions,
+		Watch:       cfg.Watch,
+	}
+	for _, m := range cfg.Modes {
+		modes.Modes = append(modes.Modes, ship.Mode{
+			State:     buildState(m.StateConfig),
+			Disabled:  m.Disabled,
+			TickRates: m.TickRates,
+			Commands:  m.Commands,
+		})
+	}
+	return s.ship.SetModes(modes)
+}
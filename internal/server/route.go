@@ -0,0 +1,55 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"spacecraftsim/internal/device"
+	"spacecraftsim/internal/parser"
+)
+
+// replyRouter delivers device replies to the client addressed by the
+// reply's destination
+type replyRouter struct {
+	*device.BaseDevice
+	server *Server
+}
+
+// Subscribe registers the router to receive replies
+func (r *replyRouter) Subscribe(bus device.Bus) error {
+	return r.SubscribeAs(bus, r)
+}
+
+// HandleInput routes a reply to its client
+func (r *replyRouter) HandleInput(msg device.Message) error {
+	if msg.Destination == "" {
+		return nil
+	}
+	return r.server.route(msg)
+}
+
+// clientFor returns the connected client for a message source
+func (s *Server) clientFor(source string) *client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clients[source]
+}
+
+// route sends a device message to the client it is addressed to
+func (s *Server) route(msg device.Message) error {
+	data, err := json.Marshal([]parser.Message{{ID: msg.ID, Values: msg.Values, CorrelationID: msg.CorrelationID}})
+	if err != nil {
+		return fmt.Errorf("failed to serialize reply: %w", err)
+	}
+
+	s.mu.Lock()
+	c, exists := s.clients[msg.Destination]
+	s.mu.Unlock()
+	if !exists {
+		log.Printf("No route to %s for reply from %s", msg.Destination, msg.Source)
+		return nil
+	}
+	s.deliver(c, append(data, '\n'))
+	return nil
+}
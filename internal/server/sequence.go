@@ -0,0 +1,134 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"spacecraftsim/internal/config"
+	"spacecraftsim/internal/device"
+	"spacecraftsim/internal/parser"
+	"spacecraftsim/internal/ship"
+)
+
+// schedule stores a time-tagged client message in the onboard sequencer
+func (s *Server) schedule(c *client, msg parser.Message, devMsg device.Message) {
+	var at time.Duration
+	if msg.At != nil {
+		at = seconds(*msg.At)
+	} else {
+		at = s.ship.SimTime() + seconds(*msg.After)
+	}
+
+	resp := parser.ResponseMessage{ID: msg.ID, CorrelationID: msg.CorrelationID}
+	cmd, err := s.ship.Schedule(devMsg, at)
+	if err != nil {
+		resp.Type = "error"
+		resp.Error = fmt.Sprintf("Failed to schedule message: %v", err)
+	} else {
+		resp.Type = "scheduled"
+		resp.Command = cmd.ID
+		resp.Values = []interface{}{cmd.ExecuteAt.Seconds()}
+	}
+	if err := c.encode(resp); err != nil {
+		log.Printf("Error sending schedule response: %v", err)
+	}
+}
+
+// reportTimedResult sends the outcome of a timed command to its sender
+func (s *Server) reportTimedResult(cmd ship.TimedCommand, err error) {
+	c := s.clientFor(cmd.Message.Source)
+	if c == nil {
+		return // Sender has disconnected
+	}
+
+	resp := parser.ResponseMessage{
+		Type:          "success",
+		ID:            cmd.Message.ID,
+		Values:        cmd.Message.Values,
+		Command:       cmd.ID,
+		CorrelationID: cmd.Message.CorrelationID,
+	}
+	if err != nil {
+		resp = parser.ResponseMessage{
+			Type:          "error",
+			ID:            cmd.Message.ID,
+			Error:         fmt.Sprintf("Failed to execute timed command: %v", err),
+			Command:       cmd.ID,
+			CorrelationID: cmd.Message.CorrelationID,
+		}
+	}
+	if err := c.encode(resp); err != nil {
+		log.Printf("Error sending timed command result: %v", err)
+	}
+}
+
+// handleSequenceCommand processes "__sequence__ list" and
+// "__sequence__ delete <command>" lines
+func (s *Server) handleSequenceCommand(c *client, line string) {
+	args := strings.Fields(strings.TrimPrefix(line, "__sequence__"))
+	resp := parser.ResponseMessage{Type: "success", ID: "__sequence__"}
+
+	switch {
+	case len(args) == 0 || args[0] == "list":
+		var list []interface{}
+		for _, cmd := range s.ship.Scheduled() {
+			list = append(list, map[string]interface{}{
+				"command": cmd.ID,
+				"at":      cmd.ExecuteAt.Seconds(),
+				"id":      cmd.Message.ID,
+				"values":  cmd.Message.Values,
+				"source":  cmd.Message.Source,
+			})
+		}
+		resp.Values = list
+	case args[0] == "delete" && len(args) == 2:
+		id, err := strconv.Atoi(args[1])
+		if err == nil {
+			err = s.ship.Unschedule(id)
+		}
+		if err != nil {
+			resp = parser.ResponseMessage{Type: "error", ID: "__sequence__", Error: err.Error()}
+		} else {
+			resp.Command = id
+		}
+	default:
+		resp = parser.ResponseMessage{Type: "error", ID: "__sequence__", Error: fmt.Sprintf("unknown sequence command: %s", strings.Join(args, " "))}
+	}
+
+	if err := c.encode(resp); err != nil {
+		log.Printf("Error sending sequence response: %v", err)
+	}
+}
+
+// scheduleFaults stores the configured faults as timed commands to the
+// fault commander
+func (s *Server) scheduleFaults(faults []config.FaultConfig) {
+	for _, f := range faults {
+		values := []interface{}{"inject", f.Device, f.Type}
+		if f.Value != nil {
+			values = append(values, *f.Value)
+		}
+		if f.Param != "" {
+			values = append(values, "param", f.Param)
+		}
+		if f.For > 0 {
+			values = append(values, "for", f.For.Seconds())
+		}
+		if f.Inputs {
+			values = append(values, "inputs", true)
+		}
+
+		msg := device.Message{ID: "faults", Values: values, Source: "config"}
+		if _, err := s.ship.Schedule(msg, f.At); err != nil {
+			log.Printf("Error scheduling %s fault on %s: %v", f.Type, f.Device, err)
+		}
+	}
+}
+
+// seconds converts seconds of simulation time to a duration
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -2,42 +2,156 @@ package server
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
+	"spacecraftsim/internal/bus"
+	"spacecraftsim/internal/config"
 	"spacecraftsim/internal/device"
+	"spacecraftsim/internal/orbit"
 	"spacecraftsim/internal/parser"
 	"spacecraftsim/internal/ship"
 	"strings"
+	"sync"
 	"time"
 )
 
+// telemetryTopics are the bus topics forwarded to clients
+// "sensors/#" covers sensors publishing on their own subtopics
+var telemetryTopics = []string{"sensors/#", "life_support", "comms", "control", "modes", "health", "power", "events"}
+
+// retainedTopics are the bus topics whose last values are kept by default,
+// events are not current values
+var retainedTopics = []string{"sensors/#", "life_support", "comms", "control", "modes", "health", "power"}
+
+// defaultRecording names the files "__record__ on" records the bus to when
+// the configuration names none
+const defaultRecording = "bus_recording.jsonl"
+
 // Server represents a TCP server
 type Server struct {
 	address  string
 	listener net.Listener
 	parser   parser.MessageParser
 	ship     *ship.Ship
+	downlink *Downlink
+	clients  map[string]*client // routes from message source to connection
+	mu       sync.Mutex
+
+	recording string       // names the files the bus is recorded to
+	metrics   *http.Server // serves the Prometheus metrics, nil when disabled
+
+	orbit          orbit.Orbit
+	stations       map[string]*groundStation
+	defaultStation string
+}
+
+// client represents a connected client
+// Writes are serialized because responses and telemetry share the connection
+type client struct {
+	conn   net.Conn
+	source string // Source of the messages the client sends
+
+	mu      sync.Mutex
+	station *groundStation // nil for a permanent link, guarded by Server.mu
+	held    [][]byte       // telemetry waiting for AOS, guarded by Server.mu
+	pending []command      // commands waiting for AOS, guarded by Server.mu
+}
+
+// write sends raw data to the client
+func (c *client) write(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.conn.Write(data)
+	return err
+}
+
+// encode sends a value to the client as a JSON line
+func (c *client) encode(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return json.NewEncoder(c.conn).Encode(v)
 }
 
 // New creates a new Server instance
-func New(address string) *Server {
+func New(address string, cfg *config.Config) *Server {
 	s := &Server{
-		address: address,
-		parser:  &parser.JSONParser{},
-		ship:    ship.New(),
+		address:  address,
+		parser:   &parser.JSONParser{},
+		ship:     ship.New(),
+		clients:  make(map[string]*client),
+		stations: make(map[string]*groundStation),
+
+		recording: defaultRecording,
+	}
+
+	if cfg.Metrics != nil {
+		s.metrics = s.newMetricsServer(cfg.Metrics.Listen)
+	}
+
+	// Set up ground stations and contact tracking
+	s.setupContacts(cfg)
+
+	// Keep events across runs
+	if cfg.EventLog != "" {
+		if err := s.ship.PersistEvents(cfg.EventLog); err != nil {
+			log.Printf("Error opening event log: %v", err)
+		}
+	}
+
+	// Deliver bus messages through subscriber queues
+	if b := cfg.Bus; b != nil && b.Delivery == "async" {
+		if err := s.ship.EnableAsyncBus(b.QueueSize, bus.OverflowPolicy(b.Overflow)); err != nil {
+			log.Printf("Error enabling async bus delivery: %v", err)
+		}
+	}
+
+	// Log the traffic on traced topics and record the bus
+	if b := cfg.Bus; b != nil {
+		s.traceBus(b.Trace)
+		if b.Record != "" {
+			s.recording = b.Record
+			if err := s.ship.RecordBus(recordingFile(b.Record, time.Now())); err != nil {
+				log.Printf("Error recording bus: %v", err)
+			}
+		}
+	}
+
+	// Keep current values for clients connecting later
+	retain := retainedTopics
+	if cfg.Bus != nil && cfg.Bus.Retain != nil {
+		retain = cfg.Bus.Retain
+	}
+	if err := s.ship.RetainTopics(retain...); err != nil {
+		log.Printf("Error retaining topics: %v", err)
 	}
 
-	// Register some example devices
-	s.registerDevices()
+	// Register some example devices and the configured ones
+	s.registerDevices(cfg)
 
 	return s
 }
 
-// registerDevices adds some example devices to the ship
-func (s *Server) registerDevices() {
+// registerDevices adds some example devices and the configured devices
+// to the ship
+func (s *Server) registerDevices(cfg *config.Config) {
+	// The avionics bus and CAN segments connect their devices as they
+	// register
+	if cfg.AvionicsBus != nil {
+		if err := s.setupAvionicsBus(cfg.AvionicsBus); err != nil {
+			log.Printf("Error setting up avionics bus: %v", err)
+		}
+	}
+	for i := range cfg.CANBuses {
+		if err := s.setupCANBus(&cfg.CANBuses[i]); err != nil {
+			log.Printf("Error setting up CAN bus %s: %v", cfg.CANBuses[i].ID, err)
+		}
+	}
+
 	// Create an example device
 	logger := device.NewLogger("logger1")
 	echo1 := device.NewEcho("echo1")
@@ -50,8 +164,107 @@ func (s *Server) registerDevices() {
 		log.Printf("Error registering echo1: %v", err)
 	}
 
-	// Start the ship
-	s.ship.Start()
+	// Life support
+	lifeSupport := []device.Device{
+		device.NewCabin("cabin"),
+		device.NewCrew("crew", 4),
+		device.NewScrubber("co2_scrubber", "cabin"),
+		device.NewO2Generator("o2_generator", 1.2e-3),
+	}
+	for _, dev := range lifeSupport {
+		if err := s.ship.RegisterDevice(dev); err != nil {
+			log.Printf("Error registering %s: %v", dev.ID(), err)
+		}
+	}
+
+	// Communications and the telemetry downlink that follows its link state
+	comms := device.NewComms("comms")
+	if err := s.ship.RegisterDevice(comms); err != nil {
+		log.Printf("Error registering comms: %v", err)
+	}
+	s.downlink = NewDownlink("downlink", comms.ID(), s.broadcast, telemetryTopics...)
+	if err := s.ship.RegisterDevice(s.downlink); err != nil {
+		log.Printf("Error registering downlink: %v", err)
+	}
+
+	// Devices defined in configuration
+	thresholds := healthThresholds(ship.DefaultHealthThresholds, cfg.Health)
+	s.ship.SetHealthThresholds(thresholds)
+	for _, devCfg := range cfg.Devices {
+		dev, err := buildDevice(devCfg)
+		if err != nil {
+			log.Printf("Error building device %s: %v", devCfg.ID, err)
+			continue
+		}
+		if err := s.ship.RegisterDevice(dev); err != nil {
+			log.Printf("Error registering %s: %v", dev.ID(), err)
+			continue
+		}
+		if devCfg.Health != nil {
+			s.ship.SetDeviceHealthThresholds(dev.ID(), healthThresholds(thresholds, devCfg.Health))
+		}
+	}
+
+	if cfg.AvionicsBus != nil {
+		s.checkTerminals(cfg.AvionicsBus)
+	}
+	for i := range cfg.CANBuses {
+		s.checkCANNodes(&cfg.CANBuses[i])
+	}
+
+	// Propagate failures and power loss between devices
+	for _, dep := range cfg.Dependencies {
+		err := s.ship.AddDependency(ship.Dependency{
+			Device:   dep.Device,
+			Upstream: dep.Upstream,
+			Effect:   ship.Effect(dep.Effect),
+		})
+		if err != nil {
+			log.Printf("Error adding dependency of %s on %s: %v", dep.Device, dep.Upstream, err)
+		}
+	}
+
+	// Redundant units behind logical devices
+	for _, g := range cfg.Redundancy {
+		auto := g.AutoFailover == nil || *g.AutoFailover
+		if err := s.ship.AddRedundancyGroup(g.ID, g.Units, g.Active, auto); err != nil {
+			log.Printf("Error adding redundancy group %s: %v", g.ID, err)
+		}
+	}
+
+	// Fault detection, isolation and recovery
+	if len(cfg.FDIR) > 0 {
+		if err := s.registerFDIR(cfg.FDIR); err != nil {
+			log.Printf("Error registering FDIR engine: %v", err)
+		}
+	}
+
+	// Ship modes, after the devices they disable and command
+	if cfg.Modes != nil {
+		if err := s.registerModes(cfg.Modes); err != nil {
+			log.Printf("Error enabling ship modes: %v", err)
+		}
+	}
+
+	// Deliver device replies to the clients that sent the messages
+	router := &replyRouter{BaseDevice: device.NewBaseDevice("reply_router", 0), server: s}
+	router.AddTopic("replies")
+	if err := s.ship.RegisterDevice(router); err != nil {
+		log.Printf("Error registering reply router: %v", err)
+	}
+
+	// Report timed command results to the clients that sent them
+	s.ship.SetResultHandler(s.reportTimedResult)
+
+	// Faults planned for the run
+	s.scheduleFaults(cfg.Faults)
+
+	// Start the ship, or feed it a recording
+	if b := cfg.Bus; b != nil && b.Replay != "" {
+		go s.replay(b.Replay, !b.ReplayFast)
+	} else {
+		s.ship.Start()
+	}
 }
 
 // Start begins listening for connections
@@ -64,6 +277,10 @@ func (s *Server) Start() error {
 
 	log.Printf("Server listening on %s", s.address)
 
+	if s.metrics != nil {
+		go s.serveMetrics()
+	}
+
 	for {
 		conn, err := s.listener.Accept()
 		if err != nil {
@@ -75,12 +292,70 @@ func (s *Server) Start() error {
 	}
 }
 
+// broadcast sends telemetry data to every connected client
+// Telemetry for clients outside their contact window is held until AOS
+func (s *Server) broadcast(data []byte) {
+	s.mu.Lock()
+	clients := make([]*client, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	// Write without the lock so a slow client only delays itself
+	for _, c := range clients {
+		s.deliver(c, data)
+	}
+}
+
+// deliver sends data to a client, holding it until AOS when the client is
+// outside its contact window
+func (s *Server) deliver(c *client, data []byte) {
+	s.mu.Lock()
+	if !s.inContact(c) {
+		s.hold(c, data)
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+
+	if err := c.write(data); err != nil {
+		log.Printf("Error sending to %s: %v", c.source, err)
+	}
+}
+
+// Stop stops the downlink, the metrics server and the ship
+func (s *Server) Stop() {
+	if s.downlink != nil {
+		s.downlink.Stop()
+	}
+	if s.metrics != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), metricsShutdownTimeout)
+		defer cancel()
+		if err := s.metrics.Shutdown(ctx); err != nil {
+			log.Printf("Error stopping metrics server: %v", err)
+		}
+	}
+	s.ship.Stop()
+}
+
 // handleConnection processes a single client connection
 func (s *Server) handleConnection(conn net.Conn) {
 	defer conn.Close()
 
 	log.Printf("New connection from %s", conn.RemoteAddr())
 
+	c := &client{conn: conn, source: conn.RemoteAddr().String()}
+	s.mu.Lock()
+	s.clients[c.source] = c
+	c.station = s.stations[s.defaultStation]
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c.source)
+		s.mu.Unlock()
+	}()
+
 	scanner := bufio.NewScanner(conn)
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -88,7 +363,7 @@ func (s *Server) handleConnection(conn net.Conn) {
 		// Check for special commands
 		if line == "__kill__" {
 			log.Printf("Received kill command from %s", conn.RemoteAddr())
-			s.ship.Stop()
+			s.Stop()
 			os.Exit(0)
 		}
 
@@ -96,6 +371,45 @@ func (s *Server) handleConnection(conn net.Conn) {
 			continue
 		}
 
+		// Uplink commands change the spacecraft and need contact like
+		// regular messages
+		if isUplinkLine(line) {
+			if s.admit(c, command{msg: parser.Message{ID: strings.Fields(line)[0]}, line: line}) {
+				s.uplink(c, line)
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "__values__") {
+			s.handleValuesCommand(c, line)
+			continue
+		}
+
+		if strings.HasPrefix(line, "__metrics__") {
+			s.handleMetricsCommand(c, line)
+			continue
+		}
+
+		if line == "__bus__" {
+			s.handleBusCommand(c)
+			continue
+		}
+
+		if strings.HasPrefix(line, "__events__") {
+			s.handleEventsCommand(c, line)
+			continue
+		}
+
+		if strings.HasPrefix(line, "__health__") {
+			s.handleHealthCommand(c, line)
+			continue
+		}
+
+		if strings.HasPrefix(line, "__station__") {
+			s.handleStationCommand(c, line)
+			continue
+		}
+
 		// Process regular messages
 		messages, err := s.parser.ParseBatch(strings.NewReader(line))
 		if err != nil {
@@ -104,7 +418,7 @@ func (s *Server) handleConnection(conn net.Conn) {
 				Type:  "error",
 				Error: fmt.Sprintf("Failed to parse message: %v", err),
 			}
-			if err := json.NewEncoder(conn).Encode(resp); err != nil {
+			if err := c.encode(resp); err != nil {
 				log.Printf("Error sending error response: %v", err)
 			}
 			continue
@@ -112,35 +426,8 @@ func (s *Server) handleConnection(conn net.Conn) {
 
 		// Process the messages
 		for _, msg := range messages {
-			// Convert parser message to device message
-			devMsg := device.Message{
-				ID:     msg.ID,
-				Values: msg.Values,
-				Time:   time.Now(),
-				Source: conn.RemoteAddr().String(),
-			}
-
-			// Route message to appropriate device
-			if err := s.ship.HandleMessage(devMsg); err != nil {
-				log.Printf("Error handling message: %v", err)
-				resp := parser.ResponseMessage{
-					Type:  "error",
-					ID:    msg.ID,
-					Error: fmt.Sprintf("Failed to handle message: %v", err),
-				}
-				if err := json.NewEncoder(conn).Encode(resp); err != nil {
-					log.Printf("Error sending error response: %v", err)
-				}
-			} else {
-				// Send success response
-				resp := parser.ResponseMessage{
-					Type:   "success",
-					ID:     msg.ID,
-					Values: msg.Values,
-				}
-				if err := json.NewEncoder(conn).Encode(resp); err != nil {
-					log.Printf("Error sending success response: %v", err)
-				}
+			if s.admit(c, command{msg: msg}) {
+				s.dispatch(c, msg)
 			}
 		}
 	}
@@ -149,3 +436,55 @@ func (s *Server) handleConnection(conn net.Conn) {
 		log.Printf("Error reading from connection: %v", err)
 	}
 }
+
+// dispatch routes a client message to its device and reports the result
+// Messages with a timeout are requests answered by the device, other
+// messages are answered by the ship
+func (s *Server) dispatch(c *client, msg parser.Message) {
+	// Convert parser message to device message
+	devMsg := device.Message{
+		ID:            msg.ID,
+		Values:        msg.Values,
+		Time:          time.Now(),
+		Source:        c.source,
+		CorrelationID: msg.CorrelationID,
+	}
+
+	// Time-tagged messages are stored by the onboard sequencer
+	if msg.At != nil || msg.After != nil {
+		s.schedule(c, msg, devMsg)
+		return
+	}
+
+	// Route message to appropriate device
+	var values []interface{}
+	var err error
+	if msg.Timeout != nil {
+		values, err = s.ship.Call(devMsg, seconds(*msg.Timeout))
+	} else {
+		values, err = s.ship.Request(devMsg)
+	}
+	if err != nil {
+		log.Printf("Error handling message: %v", err)
+		resp := parser.ResponseMessage{
+			Type:          "error",
+			ID:            msg.ID,
+			Error:         fmt.Sprintf("Failed to handle message: %v", err),
+			CorrelationID: msg.CorrelationID,
+		}
+		if err := c.encode(resp); err != nil {
+			log.Printf("Error sending error response: %v", err)
+		}
+	} else {
+		// Send success response
+		resp := parser.ResponseMessage{
+			Type:          "success",
+			ID:            msg.ID,
+			Values:        values,
+			CorrelationID: msg.CorrelationID,
+		}
+		if err := c.encode(resp); err != nil {
+			log.Printf("Error sending success response: %v", err)
+		}
+	}
+}
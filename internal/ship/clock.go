@@ -0,0 +1,41 @@
+package ship
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tracks simulation time as the time elapsed since the ship started
+// It advances in fixed steps so a run does not depend on wall clock jitter
+type Clock struct {
+	mu      sync.RWMutex
+	epoch   time.Time
+	elapsed time.Duration
+}
+
+// NewClock creates a clock starting at zero elapsed time
+func NewClock() *Clock {
+	return &Clock{epoch: time.Now()}
+}
+
+// Elapsed returns the simulation time since start
+func (c *Clock) Elapsed() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.elapsed
+}
+
+// Now returns the simulation time as an absolute timestamp
+func (c *Clock) Now() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.epoch.Add(c.elapsed)
+}
+
+// advance moves simulation time forward by step
+func (c *Clock) advance(step time.Duration) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.elapsed += step
+	return c.elapsed
+}
@@ -0,0 +1,109 @@
+package ship
+
+import (
+	"fmt"
+
+	"spacecraftsim/internal/device"
+)
+
+// Effect is what happens to a device when a device it depends on fails or
+// is switched off
+type Effect string
+
+const (
+	EffectMute    Effect = "mute"    // the device is off and goes silent
+	EffectFail    Effect = "fail"    // the device fails and goes silent
+	EffectDegrade Effect = "degrade" // the device keeps running degraded
+)
+
+// Dependency states that a device depends on an upstream device, e.g. is
+// powered by a switch or needs a data bus
+type Dependency struct {
+	Device   string
+	Upstream string
+	Effect   Effect
+}
+
+// Fault codes of propagated failures
+const (
+	FaultUpstreamOff    = "upstream_off"
+	FaultUpstreamFailed = "upstream_failed"
+)
+
+// propagationRank orders health for propagation, a device that is off
+// outranks a degraded one because it is silent
+func propagationRank(s device.HealthStatus) int {
+	switch s {
+	case device.HealthDegraded:
+		return 1
+	case device.HealthOff:
+		return 2
+	case device.HealthFailed:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// propagate applies the effects of failed and switched off devices to the
+// devices depending on them, transitively
+// own holds the health of each device on its own, deps the dependencies by
+// device. Propagated health records the root cause device.
+func propagate(own map[string]device.Health, deps map[string][]Dependency) map[string]device.Health {
+	out := make(map[string]device.Health, len(own))
+	visiting := make(map[string]bool)
+
+	var visit func(id string) device.Health
+	visit = func(id string) device.Health {
+		if h, done := out[id]; done {
+			return h
+		}
+		h := own[id]
+		if visiting[id] {
+			return h // Dependency cycle
+		}
+		visiting[id] = true
+
+		for _, dep := range deps[id] {
+			up := visit(dep.Upstream)
+			if up.Status != device.HealthOff && up.Status != device.HealthFailed {
+				continue
+			}
+
+			p := device.Health{Cause: up.Cause, Reason: up.Reason, Code: FaultUpstreamOff}
+			if p.Cause == "" {
+				// The upstream device is the root cause
+				p.Cause = dep.Upstream
+				p.Reason = fmt.Sprintf("%s is %s", dep.Upstream, up.Status)
+			}
+			if up.Status == device.HealthFailed || up.Code == FaultUpstreamFailed {
+				p.Code = FaultUpstreamFailed
+			}
+			switch dep.Effect {
+			case EffectFail:
+				p.Status = device.HealthFailed
+			case EffectDegrade:
+				p.Status = device.HealthDegraded
+			default:
+				p.Status = device.HealthOff
+			}
+			if propagationRank(p.Status) > propagationRank(h.Status) {
+				h = p
+			}
+		}
+
+		delete(visiting, id)
+		out[id] = h
+		return h
+	}
+
+	for id := range own {
+		visit(id)
+	}
+	return out
+}
+
+// silenced reports whether propagated health stops a device
+func silenced(h device.Health) bool {
+	return h.Cause != "" && (h.Status == device.HealthOff || h.Status == device.HealthFailed)
+}
@@ -0,0 +1,84 @@
+package ship
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultEventCapacity is the number of events kept in memory
+const defaultEventCapacity = 1000
+
+// Event is a notable ship occurrence such as a failover or an FDIR rule
+// triggering
+type Event struct {
+	Time   time.Duration `json:"time"` // simulation time
+	ID     string        `json:"id"`
+	Values []interface{} `json:"values"`
+}
+
+// EventLog keeps the most recent events and optionally appends every event
+// to a file as JSON lines
+type EventLog struct {
+	mu       sync.Mutex
+	capacity int
+	events   []Event
+	file     *os.File
+}
+
+// NewEventLog creates an event log keeping capacity events in memory
+func NewEventLog(capacity int) *EventLog {
+	if capacity <= 0 {
+		capacity = defaultEventCapacity
+	}
+	return &EventLog{capacity: capacity}
+}
+
+// Persist appends every following event to a file
+func (l *EventLog) Persist(path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open event log: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.file = file
+	return nil
+}
+
+// add records an event
+func (l *EventLog) add(e Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.events) == l.capacity {
+		l.events = append(l.events[:0], l.events[1:]...)
+	}
+	l.events = append(l.events, e)
+
+	if l.file != nil {
+		if err := json.NewEncoder(l.file).Encode(e); err != nil {
+			log.Printf("Error writing event log: %v", err)
+		}
+	}
+}
+
+// Last returns the last n events, or all kept events for n <= 0, oldest
+// first
+func (l *EventLog) Last(n int) []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	start := 0
+	if n > 0 && n < len(l.events) {
+		start = len(l.events) - n
+	}
+	return append([]Event(nil), l.events[start:]...)
+}
@@ -0,0 +1,407 @@
+package ship
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"spacecraftsim/internal/device"
+)
+
+// FaultType identifies the behaviour of an injected fault
+type FaultType string
+
+const (
+	FaultStuck   FaultType = "stuck"   // hold values at Value, or at the first value seen
+	FaultOffset  FaultType = "offset"  // add Value to numeric values
+	FaultDrift   FaultType = "drift"   // add Value per second since injection
+	FaultDropout FaultType = "dropout" // drop messages with probability Value
+	FaultNoise   FaultType = "noise"   // add gaussian noise with sigma Value
+	FaultDelay   FaultType = "delay"   // deliver messages Value seconds late
+	FaultFailure FaultType = "failure" // stop ticking, reject inputs and drop outputs
+)
+
+// Fault is a fault injected into a device
+// Faults apply to the messages the device publishes, or to the messages it
+// receives when Inputs is set. Faults on the same device apply in order.
+type Fault struct {
+	ID       int
+	Device   string
+	Type     FaultType
+	Value    *float64
+	Param    string        // only messages with this ID, empty for all
+	Inputs   bool          // apply to inputs instead of outputs
+	Start    time.Duration // simulation time of injection
+	Duration time.Duration // cleared after, 0 until cleared
+
+	held map[string]float64 // stuck values by message ID and index
+}
+
+// validate checks the fault parameters
+func (f *Fault) validate() error {
+	switch f.Type {
+	case FaultStuck, FaultFailure:
+	case FaultOffset, FaultDrift, FaultNoise:
+		if f.Value == nil {
+			return fmt.Errorf("%s fault needs a value", f.Type)
+		}
+	case FaultDropout:
+		if f.Value == nil || *f.Value < 0 || *f.Value > 1 {
+			return fmt.Errorf("dropout fault needs a probability between 0 and 1")
+		}
+	case FaultDelay:
+		if f.Value == nil || *f.Value <= 0 {
+			return fmt.Errorf("delay fault needs a positive delay")
+		}
+	default:
+		return fmt.Errorf("unknown fault type %s", f.Type)
+	}
+	if f.Duration < 0 {
+		return fmt.Errorf("fault duration cannot be negative")
+	}
+	return nil
+}
+
+// delayedMessage is a message held back by a delay fault
+type delayedMessage struct {
+	due   time.Duration
+	port  *port
+	topic string // empty for an input
+	msg   device.Message
+}
+
+// FaultInjector holds the injected faults and applies them to the messages
+// passing through device ports
+type FaultInjector struct {
+	mu      sync.Mutex
+	clock   *Clock
+	nextID  int
+	faults  []*Fault // in injection order
+	delayed []delayedMessage
+}
+
+// NewFaultInjector creates an injector without faults
+func NewFaultInjector(clock *Clock) *FaultInjector {
+	return &FaultInjector{clock: clock, nextID: 1}
+}
+
+// Inject adds a fault and returns it with its assigned ID and start time
+func (fi *FaultInjector) Inject(f Fault) (Fault, error) {
+	if err := f.validate(); err != nil {
+		return Fault{}, err
+	}
+
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	f.ID = fi.nextID
+	fi.nextID++
+	f.Start = fi.clock.Elapsed()
+	f.held = make(map[string]float64)
+	fi.faults = append(fi.faults, &f)
+	log.Printf("Injected %s fault %d into %s", f.Type, f.ID, f.Device)
+	return f, nil
+}
+
+// Clear removes a fault
+func (fi *FaultInjector) Clear(id int) error {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	for i, f := range fi.faults {
+		if f.ID == id {
+			fi.faults = append(fi.faults[:i], fi.faults[i+1:]...)
+			log.Printf("Cleared %s fault %d from %s", f.Type, f.ID, f.Device)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown fault: %d", id)
+}
+
+// ClearDevice removes the faults of a device, or of every device for an
+// empty ID, and returns how many were removed
+func (fi *FaultInjector) ClearDevice(dev string) int {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	kept := fi.faults[:0]
+	for _, f := range fi.faults {
+		if dev != "" && f.Device != dev {
+			kept = append(kept, f)
+		}
+	}
+	n := len(fi.faults) - len(kept)
+	fi.faults = kept
+	return n
+}
+
+// List returns the active faults in injection order
+func (fi *FaultInjector) List() []Fault {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	list := make([]Fault, len(fi.faults))
+	for i, f := range fi.faults {
+		list[i] = *f
+		list[i].held = nil
+	}
+	return list
+}
+
+// failed reports whether a device has a failure fault
+func (fi *FaultInjector) failed(dev string) bool {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	for _, f := range fi.faults {
+		if f.Device == dev && f.Type == FaultFailure {
+			return true
+		}
+	}
+	return false
+}
+
+// input delivers a message to a device through its input faults
+func (fi *FaultInjector) input(p *port, msg device.Message) error {
+	if fi.failed(p.ID()) {
+		return fmt.Errorf("device %s is not responding", p.ID())
+	}
+	msg, deliver := fi.apply(p, "", msg, true)
+	if !deliver {
+		return nil
+	}
+	return p.receive(msg)
+}
+
+// output publishes a device message through its output faults
+func (fi *FaultInjector) output(p *port, topic string, msg device.Message) error {
+	if fi.failed(p.ID()) {
+		return nil
+	}
+	msg, deliver := fi.apply(p, topic, msg, false)
+	if !deliver {
+		return nil
+	}
+	return p.publish(topic, msg)
+}
+
+// apply runs a message through the faults of its device and reports
+// whether it should be delivered now. Delayed messages are queued.
+func (fi *FaultInjector) apply(p *port, topic string, msg device.Message, inputs bool) (device.Message, bool) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	now := fi.clock.Elapsed()
+	copied := false
+	for _, f := range fi.faults {
+		if f.Device != p.ID() || f.Inputs != inputs || (f.Param != "" && f.Param != msg.ID) {
+			continue
+		}
+		switch f.Type {
+		case FaultDropout:
+			if rand.Float64() < *f.Value {
+				return msg, false
+			}
+		case FaultDelay:
+			due := now + time.Duration(*f.Value*float64(time.Second))
+			fi.delayed = append(fi.delayed, delayedMessage{due: due, port: p, topic: topic, msg: msg})
+			return msg, false
+		case FaultStuck, FaultOffset, FaultDrift, FaultNoise:
+			if !copied {
+				msg.Values = append([]interface{}{}, msg.Values...)
+				copied = true
+			}
+			for i, v := range msg.Values {
+				if x, ok := v.(float64); ok {
+					msg.Values[i] = f.corrupt(fmt.Sprintf("%s[%d]", msg.ID, i), x, now)
+				}
+			}
+		}
+	}
+	return msg, true
+}
+
+// corrupt applies a value fault to a numeric value
+func (f *Fault) corrupt(key string, x float64, now time.Duration) float64 {
+	switch f.Type {
+	case FaultStuck:
+		if f.Value != nil {
+			return *f.Value
+		}
+		if held, ok := f.held[key]; ok {
+			return held
+		}
+		f.held[key] = x
+		return x
+	case FaultOffset:
+		return x + *f.Value
+	case FaultDrift:
+		return x + *f.Value*(now-f.Start).Seconds()
+	case FaultNoise:
+		return x + rand.NormFloat64()**f.Value
+	}
+	return x
+}
+
+// release clears expired faults and delivers the delayed messages that
+// are due, dropping those of devices silenced or failed since
+func (fi *FaultInjector) release(now time.Duration) {
+	fi.mu.Lock()
+	active := fi.faults[:0]
+	for _, f := range fi.faults {
+		if f.Duration > 0 && now >= f.Start+f.Duration {
+			log.Printf("%s fault %d on %s expired", f.Type, f.ID, f.Device)
+			continue
+		}
+		active = append(active, f)
+	}
+	fi.faults = active
+
+	var due []delayedMessage
+	remaining := fi.delayed[:0]
+	for _, d := range fi.delayed {
+		if d.due <= now {
+			due = append(due, d)
+		} else {
+			remaining = append(remaining, d)
+		}
+	}
+	fi.delayed = remaining
+	fi.mu.Unlock()
+
+	for _, d := range due {
+		// A device silenced or failed meanwhile neither receives nor sends
+		if d.port.silenced.Load() || fi.failed(d.port.ID()) {
+			continue
+		}
+		var err error
+		if d.topic == "" {
+			err = d.port.receive(d.msg)
+		} else {
+			err = d.port.publish(d.topic, d.msg)
+		}
+		if err != nil {
+			log.Printf("Error delivering delayed message for %s: %v", d.port.ID(), err)
+		}
+	}
+}
+
+// faultCommander is the "faults" device through which clients, timed
+// commands and other devices inject and clear faults
+type faultCommander struct {
+	*device.BaseDevice
+	ship *Ship
+}
+
+// HandleInput executes a fault command
+func (c *faultCommander) HandleInput(msg device.Message) error {
+	_, err := c.Query(msg)
+	return err
+}
+
+// Query executes a fault command and returns its result
+// Commands: ["inject", device, type, value?, option, value, ...] with the
+// options "param", "for" (seconds) and "inputs" (bool), ["clear", fault],
+// ["clear", device], ["clear", "all"] and ["list"]
+func (c *faultCommander) Query(msg device.Message) ([]interface{}, error) {
+	if len(msg.Values) == 0 {
+		return nil, fmt.Errorf("faults: missing command")
+	}
+	args := msg.Values[1:]
+
+	switch cmd := strings.ToLower(fmt.Sprint(msg.Values[0])); cmd {
+	case "list":
+		var out []interface{}
+		for _, f := range c.ship.Faults() {
+			entry := map[string]interface{}{
+				"fault":  f.ID,
+				"device": f.Device,
+				"type":   f.Type,
+				"start":  f.Start.Seconds(),
+				"inputs": f.Inputs,
+			}
+			if f.Value != nil {
+				entry["value"] = *f.Value
+			}
+			if f.Param != "" {
+				entry["param"] = f.Param
+			}
+			if f.Duration > 0 {
+				entry["for"] = f.Duration.Seconds()
+			}
+			out = append(out, entry)
+		}
+		return out, nil
+	case "clear":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("faults: clear needs a fault ID, a device or all")
+		}
+		target := fmt.Sprint(args[0])
+		if id, err := device.ToFloat(args[0]); err == nil {
+			if err := c.ship.ClearFault(int(id)); err != nil {
+				return nil, err
+			}
+			return []interface{}{target}, nil
+		}
+		if target == "all" {
+			target = ""
+		}
+		return []interface{}{c.ship.ClearFaults(target)}, nil
+	case "inject":
+		f, err := parseFault(args)
+		if err != nil {
+			return nil, fmt.Errorf("faults: %w", err)
+		}
+		f, err = c.ship.InjectFault(f)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{f.ID}, nil
+	default:
+		return nil, fmt.Errorf("faults: unknown command %s", cmd)
+	}
+}
+
+// parseFault reads [device, type, value?, option, value, ...]
+func parseFault(args []interface{}) (Fault, error) {
+	if len(args) < 2 {
+		return Fault{}, fmt.Errorf("inject needs a device and a fault type")
+	}
+	f := Fault{Device: fmt.Sprint(args[0]), Type: FaultType(strings.ToLower(fmt.Sprint(args[1])))}
+	args = args[2:]
+
+	if len(args) > 0 {
+		if v, err := device.ToFloat(args[0]); err == nil {
+			f.Value = &v
+			args = args[1:]
+		}
+	}
+	for ; len(args) > 0; args = args[2:] {
+		if len(args) < 2 {
+			return Fault{}, fmt.Errorf("missing value for %v", args[0])
+		}
+		option, value := fmt.Sprint(args[0]), args[1]
+		switch option {
+		case "param":
+			f.Param = fmt.Sprint(value)
+		case "for":
+			secs, err := device.ToFloat(value)
+			if err != nil {
+				return Fault{}, fmt.Errorf("invalid duration: %w", err)
+			}
+			f.Duration = time.Duration(secs * float64(time.Second))
+		case "inputs":
+			on, err := device.ToBool(value)
+			if err != nil {
+				return Fault{}, fmt.Errorf("invalid inputs flag: %w", err)
+			}
+			f.Inputs = on
+		default:
+			return Fault{}, fmt.Errorf("unknown option %s", option)
+		}
+	}
+	return f, nil
+}
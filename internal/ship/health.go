@@ -0,0 +1,228 @@
+package ship
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"spacecraftsim/internal/device"
+)
+
+// Fault codes raised from device errors
+const (
+	FaultTickErrors  = "tick_errors"
+	FaultInputErrors = "input_errors"
+)
+
+// HealthThresholds sets how many errors degrade or fail a device
+// Each error increments a count and each success decrements it, so a
+// device recovers once it works again. A zero threshold disables the level.
+type HealthThresholds struct {
+	Degraded int
+	Failed   int
+}
+
+// DefaultHealthThresholds are used for devices without their own thresholds
+var DefaultHealthThresholds = HealthThresholds{Degraded: 3, Failed: 10}
+
+// deviceHealth tracks the errors and current health of a device
+type deviceHealth struct {
+	thresholds  *HealthThresholds // nil uses the monitor defaults
+	tickErrors  int
+	inputErrors int
+	lastTickErr error
+	lastInErr   error
+	health      device.Health
+}
+
+// HealthMonitor derives device health from device errors and the health
+// devices report themselves
+type HealthMonitor struct {
+	mu       sync.Mutex
+	defaults HealthThresholds
+	devices  map[string]*deviceHealth
+}
+
+// NewHealthMonitor creates a monitor using the default thresholds
+func NewHealthMonitor() *HealthMonitor {
+	return &HealthMonitor{
+		defaults: DefaultHealthThresholds,
+		devices:  make(map[string]*deviceHealth),
+	}
+}
+
+// entry returns the health record of a device, creating it if needed
+// The caller must hold m.mu
+func (m *HealthMonitor) entry(id string) *deviceHealth {
+	e, exists := m.devices[id]
+	if !exists {
+		e = &deviceHealth{}
+		m.devices[id] = e
+	}
+	return e
+}
+
+// SetThresholds sets the default thresholds
+func (m *HealthMonitor) SetThresholds(t HealthThresholds) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.defaults = t
+}
+
+// SetDeviceThresholds sets the thresholds of a single device
+func (m *HealthMonitor) SetDeviceThresholds(id string, t HealthThresholds) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entry(id).thresholds = &t
+}
+
+// RecordTick records the result of a device tick
+func (m *HealthMonitor) RecordTick(id string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := m.entry(id)
+	e.tickErrors = count(e.tickErrors, err)
+	if err != nil {
+		e.lastTickErr = err
+	}
+}
+
+// RecordInput records the result of a device handling a message
+func (m *HealthMonitor) RecordInput(id string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := m.entry(id)
+	e.inputErrors = count(e.inputErrors, err)
+	if err != nil {
+		e.lastInErr = err
+	}
+}
+
+// count increments an error count on error and decrements it on success
+func count(n int, err error) int {
+	if err != nil {
+		return n + 1
+	}
+	if n > 0 {
+		return n - 1
+	}
+	return 0
+}
+
+// Reset clears the error counts of a device
+func (m *HealthMonitor) Reset(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := m.entry(id)
+	e.tickErrors, e.inputErrors = 0, 0
+	e.lastTickErr, e.lastInErr = nil, nil
+}
+
+// Evaluate combines the error counts of a device with the health it
+// reports without changing the current health
+// An empty reported status counts as nominal.
+func (m *HealthMonitor) Evaluate(id string, reported device.Health) device.Health {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.derive(m.entry(id), reported)
+}
+
+// Update combines the error counts of a device with the health it reports
+// and returns the resulting health and whether its status, fault code or
+// cause changed. An empty reported status counts as nominal.
+func (m *HealthMonitor) Update(id string, reported device.Health, now time.Time) (device.Health, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := m.entry(id)
+	h := m.derive(e, reported)
+	if h.Status == e.health.Status && h.Code == e.health.Code && h.Cause == e.health.Cause {
+		e.health.Reason = h.Reason
+		return e.health, false
+	}
+	h.Since = now
+	e.health = h
+	return h, true
+}
+
+// derive computes the health of a device
+// The caller must hold m.mu
+func (m *HealthMonitor) derive(e *deviceHealth, reported device.Health) device.Health {
+	h := reported
+	if h.Status == "" {
+		h.Status = device.HealthNominal
+	}
+	if h.Status == device.HealthOff {
+		return h
+	}
+
+	t := m.defaults
+	if e.thresholds != nil {
+		t = *e.thresholds
+	}
+	n, code, last := e.tickErrors, FaultTickErrors, e.lastTickErr
+	if e.inputErrors > n {
+		n, code, last = e.inputErrors, FaultInputErrors, e.lastInErr
+	}
+
+	var status device.HealthStatus
+	switch {
+	case t.Failed > 0 && n >= t.Failed:
+		status = device.HealthFailed
+	case t.Degraded > 0 && n >= t.Degraded:
+		status = device.HealthDegraded
+	default:
+		return h
+	}
+	if status.Severity() <= h.Status.Severity() {
+		return h
+	}
+	return device.Health{
+		Status: status,
+		Code:   code,
+		Reason: fmt.Sprintf("%d errors, last: %v", n, last),
+	}
+}
+
+// Get returns the current health of a device
+func (m *HealthMonitor) Get(id string) device.Health {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, exists := m.devices[id]
+	if !exists || e.health.Status == "" {
+		return device.Health{Status: device.HealthNominal}
+	}
+	return e.health
+}
+
+// All returns the current health of every known device
+func (m *HealthMonitor) All() map[string]device.Health {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	all := make(map[string]device.Health, len(m.devices))
+	for id, e := range m.devices {
+		if e.health.Status != "" {
+			all[id] = e.health
+		}
+	}
+	return all
+}
+
+// Overall returns the worst status of all devices
+func (m *HealthMonitor) Overall() device.HealthStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	overall := device.HealthNominal
+	for _, e := range m.devices {
+		if e.health.Status.Severity() > overall.Severity() {
+			overall = e.health.Status
+		}
+	}
+	return overall
+}
@@ -0,0 +1,174 @@
+package ship
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds of the latency histograms
+var LatencyBuckets = []time.Duration{
+	10 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// Histogram counts durations in the LatencyBuckets
+// Counts[i] is the number of durations up to LatencyBuckets[i], the last
+// count those above every bound.
+type Histogram struct {
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+	Max    time.Duration
+}
+
+// newHistogram creates an empty histogram
+func newHistogram() Histogram {
+	return Histogram{Counts: make([]uint64, len(LatencyBuckets)+1)}
+}
+
+// observe counts a duration
+func (h *Histogram) observe(d time.Duration) {
+	i := sort.Search(len(LatencyBuckets), func(i int) bool { return d <= LatencyBuckets[i] })
+	h.Counts[i]++
+	h.Count++
+	h.Sum += d
+	if d > h.Max {
+		h.Max = d
+	}
+}
+
+// Mean returns the mean duration, zero without observations
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// copy returns a histogram that no longer shares its counts
+func (h Histogram) copy() Histogram {
+	h.Counts = append([]uint64(nil), h.Counts...)
+	return h
+}
+
+// DeviceMetrics are the runtime metrics of a device
+// Latencies are wall time spent in HandleInput, including queries
+// answered for requests, and in Tick; with synchronous delivery they
+// include the handling of the messages the device publishes meanwhile. A
+// tick overruns when it takes longer than the tick rate of the device.
+type DeviceMetrics struct {
+	Device       string
+	Inputs       Histogram
+	InputErrors  uint64
+	Ticks        Histogram
+	TickErrors   uint64
+	TickOverruns uint64
+}
+
+// LoopMetrics are the runtime metrics of the ship loop
+// A step overruns when it takes longer than the base tick, the loop then
+// falls behind wall time.
+type LoopMetrics struct {
+	Steps    Histogram
+	Overruns uint64
+}
+
+// Metrics collects the runtime metrics of the devices and the ship loop
+type Metrics struct {
+	mu      sync.Mutex
+	devices map[string]*DeviceMetrics
+	loop    LoopMetrics
+}
+
+// NewMetrics creates empty metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		devices: make(map[string]*DeviceMetrics),
+		loop:    LoopMetrics{Steps: newHistogram()},
+	}
+}
+
+// entry returns the metrics of a device, creating them if needed
+// The caller must hold m.mu
+func (m *Metrics) entry(id string) *DeviceMetrics {
+	e, exists := m.devices[id]
+	if !exists {
+		e = &DeviceMetrics{Device: id, Inputs: newHistogram(), Ticks: newHistogram()}
+		m.devices[id] = e
+	}
+	return e
+}
+
+// RecordInput records a message handled by a device
+func (m *Metrics) RecordInput(id string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := m.entry(id)
+	e.Inputs.observe(d)
+	if err != nil {
+		e.InputErrors++
+	}
+}
+
+// RecordTick records a device tick and whether it overran the tick rate
+func (m *Metrics) RecordTick(id string, d, tickRate time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := m.entry(id)
+	e.Ticks.observe(d)
+	if err != nil {
+		e.TickErrors++
+	}
+	if tickRate > 0 && d > tickRate {
+		e.TickOverruns++
+	}
+}
+
+// RecordStep records a step of the ship loop
+func (m *Metrics) RecordStep(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.loop.Steps.observe(d)
+	if d > baseTickInterval {
+		m.loop.Overruns++
+	}
+}
+
+// Devices returns the metrics of every device that handled a message or
+// ticked, ordered by device
+func (m *Metrics) Devices() []DeviceMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]DeviceMetrics, 0, len(m.devices))
+	for _, e := range m.devices {
+		d := *e
+		d.Inputs, d.Ticks = e.Inputs.copy(), e.Ticks.copy()
+		list = append(list, d)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Device < list[j].Device })
+	return list
+}
+
+// Loop returns the metrics of the ship loop
+func (m *Metrics) Loop() LoopMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	loop := m.loop
+	loop.Steps = m.loop.Steps.copy()
+	return loop
+}
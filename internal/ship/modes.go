@@ -0,0 +1,258 @@
+package ship
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"spacecraftsim/internal/device"
+)
+
+// modeManagerID is the device ID of the ship mode manager
+const modeManagerID = "mode"
+
+// FaultModeDisabled is the fault code of devices switched off by the mode
+const FaultModeDisabled = "mode_disabled"
+
+// Mode is an operating mode of the ship
+type Mode struct {
+	device.State
+	Disabled  []string                 // devices switched off in the mode
+	TickRates map[string]time.Duration // tick rates replacing the device defaults
+	// Commands accepted from outside the ship as "<device>" for any
+	// command or "<device>:<command>" for a single one, nil accepts all
+	Commands []string
+}
+
+// ModeConfig holds the modes of the ship and the transitions between them
+type ModeConfig struct {
+	Initial     string // defaults to the first mode
+	Modes       []Mode
+	Transitions []device.Transition
+	Watch       []string // topics providing the values used by guards
+}
+
+// modeManager is the ship mode state machine
+// Clients request a mode by name, e.g. ["safe"]; automatic transitions
+// are taken when their guards hold. The current mode is published on the
+// "modes" topic as "mode" and every transition is recorded as the
+// "mode.transition" event with the previous mode, the new mode and the
+// reason.
+type modeManager struct {
+	*device.StateMachine
+	ship    *Ship
+	modes   map[string]*Mode
+	mu      sync.Mutex
+	current *Mode // mode applied to the devices
+}
+
+// SetModes enables ship modes
+// Devices disabled by the current mode are off, with the mode manager as
+// the cause, and commands from outside the ship are limited to the
+// commands the mode accepts
+func (s *Ship) SetModes(cfg ModeConfig) error {
+	s.mu.RLock()
+	known := func(id string) bool {
+		_, exists := s.devices[id]
+		return exists || id == modeManagerID
+	}
+	modes := make(map[string]*Mode, len(cfg.Modes))
+	var states []device.State
+	for i := range cfg.Modes {
+		mode := &cfg.Modes[i]
+		for _, id := range mode.Disabled {
+			if !known(id) {
+				s.mu.RUnlock()
+				return fmt.Errorf("mode %s: unknown device %s", mode.Name, id)
+			}
+			if id == modeManagerID || id == "faults" {
+				s.mu.RUnlock()
+				return fmt.Errorf("mode %s: %s cannot be disabled", mode.Name, id)
+			}
+		}
+		for id, rate := range mode.TickRates {
+			if !known(id) {
+				s.mu.RUnlock()
+				return fmt.Errorf("mode %s: unknown device %s", mode.Name, id)
+			}
+			if rate < 0 {
+				s.mu.RUnlock()
+				return fmt.Errorf("mode %s: tick rate of %s cannot be negative", mode.Name, id)
+			}
+		}
+		for _, cmd := range mode.Commands {
+			if id, _, _ := strings.Cut(cmd, ":"); !known(id) {
+				s.mu.RUnlock()
+				return fmt.Errorf("mode %s: unknown device %s", mode.Name, id)
+			}
+		}
+		modes[mode.Name] = mode
+		states = append(states, mode.State)
+	}
+	s.mu.RUnlock()
+
+	sm, err := device.NewStateMachine(modeManagerID, device.StateMachineConfig{
+		Initial:     cfg.Initial,
+		States:      states,
+		Transitions: cfg.Transitions,
+		Watch:       cfg.Watch,
+	})
+	if err != nil {
+		return err
+	}
+
+	m := &modeManager{
+		StateMachine: sm,
+		ship:         s,
+		modes:        modes,
+		current:      modes[sm.State()],
+	}
+	if err := s.RegisterDevice(m); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.modes = m
+	s.mu.Unlock()
+	s.applyMode(m.current)
+	return nil
+}
+
+// Mode returns the current ship mode, empty without modes
+func (s *Ship) Mode() string {
+	s.mu.RLock()
+	m := s.modes
+	s.mu.RUnlock()
+
+	if m == nil {
+		return ""
+	}
+	return m.mode().Name
+}
+
+// applyMode sets the tick rates of a mode
+func (s *Ship) applyMode(mode *Mode) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for id, p := range s.devices {
+		p.tickRate.Store(int64(mode.TickRates[id]))
+	}
+}
+
+// checkMode rejects commands from outside the ship that the current mode
+// does not accept. Devices may always command each other.
+func (s *Ship) checkMode(msg device.Message) error {
+	s.mu.RLock()
+	m := s.modes
+	_, onboard := s.devices[msg.Source]
+	s.mu.RUnlock()
+
+	if m == nil || onboard || msg.Source == "ship" {
+		return nil
+	}
+	return m.accept(msg)
+}
+
+// mode returns the mode applied to the devices
+func (m *modeManager) mode() *Mode {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.current
+}
+
+// HandleInput records watched values and processes mode requests
+func (m *modeManager) HandleInput(msg device.Message) error {
+	err := m.StateMachine.HandleInput(msg)
+	if msg.ID == m.ID() {
+		m.update("commanded by " + msg.Source)
+	}
+	return err
+}
+
+// Tick takes automatic and timeout transitions
+func (m *modeManager) Tick() error {
+	err := m.StateMachine.Tick()
+	m.update("automatic")
+	return err
+}
+
+// Query answers ["status"] with the current mode and its restrictions,
+// other commands are mode requests answered with the resulting mode
+func (m *modeManager) Query(msg device.Message) ([]interface{}, error) {
+	if len(msg.Values) == 1 && strings.EqualFold(fmt.Sprint(msg.Values[0]), "status") {
+		mode := m.mode()
+		rates := make(map[string]interface{}, len(mode.TickRates))
+		for id, rate := range mode.TickRates {
+			rates[id] = rate.String()
+		}
+		return []interface{}{map[string]interface{}{
+			"mode":       mode.Name,
+			"disabled":   mode.Disabled,
+			"tick_rates": rates,
+			"commands":   mode.Commands,
+		}}, nil
+	}
+
+	if err := m.HandleInput(msg); err != nil {
+		return nil, err
+	}
+	return []interface{}{m.mode().Name}, nil
+}
+
+// update applies the mode the state machine moved to and records the
+// transition
+func (m *modeManager) update(reason string) {
+	m.mu.Lock()
+	from := m.current
+	to := m.modes[m.State()]
+	if to == from {
+		m.mu.Unlock()
+		return
+	}
+	m.current = to
+	m.mu.Unlock()
+
+	log.Printf("Ship mode %s -> %s: %s", from.Name, to.Name, reason)
+	m.ship.applyMode(to)
+	m.ship.RecordEvent(m.ID()+".transition", from.Name, to.Name, reason)
+}
+
+// disabled returns the health of a device switched off by the current mode
+func (m *modeManager) disabled(id string) (device.Health, bool) {
+	mode := m.mode()
+	for _, d := range mode.Disabled {
+		if d == id {
+			return device.Health{
+				Status: device.HealthOff,
+				Code:   FaultModeDisabled,
+				Reason: fmt.Sprintf("disabled in %s mode", mode.Name),
+				Cause:  m.ID(),
+			}, true
+		}
+	}
+	return device.Health{}, false
+}
+
+// accept checks that the current mode accepts a command
+// Mode requests and fault injection are always accepted
+func (m *modeManager) accept(msg device.Message) error {
+	mode := m.mode()
+	if mode.Commands == nil || msg.ID == m.ID() || msg.ID == "faults" {
+		return nil
+	}
+
+	var cmd string
+	if len(msg.Values) > 0 {
+		cmd = fmt.Sprint(msg.Values[0])
+	}
+	for _, allowed := range mode.Commands {
+		id, name, single := strings.Cut(allowed, ":")
+		if id == msg.ID && (!single || strings.EqualFold(name, cmd)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("command %s %s is not allowed in %s mode", msg.ID, cmd, mode.Name)
+}
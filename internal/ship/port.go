@@ -0,0 +1,162 @@
+package ship
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"spacecraftsim/internal/device"
+)
+
+// port connects a registered device to the ship bus
+// Every message the device receives or publishes passes through the fault
+// injector, so faults apply to any device without changing it. A silenced
+// device, e.g. one whose power is off, neither ticks nor sends nor receives.
+type port struct {
+	dev      device.Device
+	bus      device.Bus
+	faults   *FaultInjector
+	clock    *Clock
+	metrics  *Metrics
+	silenced atomic.Bool
+	group    atomic.Pointer[redundancyGroup] // nil unless the device is a redundant unit
+	tickRate atomic.Int64                    // tick rate set by the ship mode, 0 for the device default
+}
+
+// ID returns the identifier of the device
+func (p *port) ID() string {
+	return p.dev.ID()
+}
+
+// HandleInput delivers a message to the device through its input faults
+// Messages to a silenced device are lost
+func (p *port) HandleInput(msg device.Message) error {
+	if p.silenced.Load() {
+		return nil
+	}
+	return p.faults.input(p, msg)
+}
+
+// receive passes a message that went through the input faults to the
+// device and records how long the device took
+func (p *port) receive(msg device.Message) error {
+	start := time.Now()
+	err := p.handle(msg)
+	p.metrics.RecordInput(p.ID(), time.Since(start), err)
+	return err
+}
+
+// handle delivers a message to the device
+// Requests to a queryable device are answered with the result of its
+// query. Devices replying to a request themselves are only answered when
+// they fail to handle it, so the requester does not wait in vain; other
+// devices get an empty reply acknowledging the request once handled.
+func (p *port) handle(msg device.Message) error {
+	if msg.ReplyTo == "" || msg.ID != p.ID() {
+		return p.dev.HandleInput(msg)
+	}
+
+	var values []interface{}
+	var err error
+	if q, ok := p.dev.(device.Queryable); ok {
+		values, err = q.Query(msg)
+	} else {
+		err = p.dev.HandleInput(msg)
+		if r, ok := p.dev.(device.Replier); ok && err == nil && r.RepliesTo(msg) {
+			return nil
+		}
+	}
+	topic, reply := device.NewReply(msg, p.ID(), values, err, p.clock.Now())
+	if perr := p.faults.output(p, topic, reply); perr != nil {
+		return perr
+	}
+	return err
+}
+
+// Tick ticks the device unless it is silenced or has failed, recording
+// how long it took
+func (p *port) Tick() error {
+	if p.silenced.Load() {
+		return nil
+	}
+	if p.faults.failed(p.dev.ID()) {
+		return fmt.Errorf("device %s is not responding", p.dev.ID())
+	}
+	start := time.Now()
+	err := p.dev.Tick()
+	p.metrics.RecordTick(p.ID(), time.Since(start), p.GetTickRate(), err)
+	return err
+}
+
+// Subscribe subscribes the device through the port
+func (p *port) Subscribe(bus device.Bus) error {
+	return p.dev.Subscribe(&portBus{port: p})
+}
+
+// GetTickRate returns the tick rate of the ship mode or of the device
+func (p *port) GetTickRate() time.Duration {
+	if rate := p.tickRate.Load(); rate > 0 {
+		return time.Duration(rate)
+	}
+	return p.dev.GetTickRate()
+}
+
+// publish sends a device message on the bus
+// Messages of an active redundant unit are also sent under the group ID;
+// replies to clients are only sent under the group ID and replies to
+// requests, addressed to the unit itself, only under the unit ID
+func (p *port) publish(topic string, msg device.Message) error {
+	g := p.group.Load()
+	if g == nil || !g.isActive(p.ID()) || (topic != "replies" && msg.Destination != "") {
+		return p.bus.Publish(topic, msg)
+	}
+	id, ok := g.logical(p.ID(), msg.ID)
+	if !ok {
+		return p.bus.Publish(topic, msg)
+	}
+
+	logical := msg
+	logical.ID = id
+	if topic == "replies" {
+		return p.bus.Publish(topic, logical)
+	}
+	if err := p.bus.Publish(topic, msg); err != nil {
+		return err
+	}
+	return p.bus.Publish(topic, logical)
+}
+
+// portBus is the bus as seen by a device behind a port
+type portBus struct {
+	port *port
+}
+
+// Publish sends a device output through its output faults
+func (b *portBus) Publish(topic string, msg device.Message) error {
+	if b.port.silenced.Load() {
+		return nil
+	}
+	return b.port.faults.output(b.port, topic, msg)
+}
+
+// Subscribe registers the port in place of the device
+func (b *portBus) Subscribe(topic string, _ device.Device) error {
+	return b.port.bus.Subscribe(topic, b.port)
+}
+
+// Request sends a device request and waits for the reply
+func (b *portBus) Request(topic string, msg device.Message, timeout time.Duration) (device.Message, error) {
+	if b.port.silenced.Load() {
+		return device.Message{}, fmt.Errorf("device %s is silenced", b.port.ID())
+	}
+	r, ok := b.port.bus.(device.Requester)
+	if !ok {
+		return device.Message{}, fmt.Errorf("device %s: bus does not support requests", b.port.ID())
+	}
+	return r.Request(topic, msg, timeout)
+}
+
+// Unsubscribe removes the port subscription
+func (b *portBus) Unsubscribe(topic string, _ device.Device) error {
+	return b.port.bus.Unsubscribe(topic, b.port)
+}
@@ -0,0 +1,125 @@
+package ship
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"spacecraftsim/internal/bus"
+)
+
+// RecordBus writes every message published on the bus to a file, one
+// JSON line per message with the simulation time since the recording
+// started, for Replay
+// An existing file is never overwritten, a recording holds a single
+// session.
+func (s *Ship) RecordBus(path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open bus recording: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.recording != nil {
+		file.Close()
+		return fmt.Errorf("bus is already recorded to %s", s.recording.Name())
+	}
+	start := s.clock.Elapsed()
+	now := func() time.Duration { return s.clock.Elapsed() - start }
+	if err := s.bus.StartRecording(file, now); err != nil {
+		file.Close()
+		return err
+	}
+	s.recording = file
+	log.Printf("Recording bus to %s", path)
+	return nil
+}
+
+// StopBusRecording stops recording the bus and closes the recording
+func (s *Ship) StopBusRecording() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.recording == nil {
+		return fmt.Errorf("bus is not recorded")
+	}
+	if err := s.bus.StopRecording(); err != nil {
+		return err
+	}
+	log.Printf("Stopped recording bus to %s", s.recording.Name())
+	err := s.recording.Close()
+	s.recording = nil
+	return err
+}
+
+// BusRecording returns the file the bus is recorded to, empty when it is
+// not recorded
+func (s *Ship) BusRecording() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.recording == nil {
+		return ""
+	}
+	return s.recording.Name()
+}
+
+// Replay feeds a bus recording back into the ship in place of Start
+// The ship steps through simulation time itself, at the original pace or
+// as fast as possible, and publishes every recorded message at its
+// recorded simulation time. Messages published by the devices are dropped
+// meanwhile, so subscribers and clients see the recorded traffic exactly.
+// Replay returns when the recording ends or the ship is stopped.
+func (s *Ship) Replay(path string, realtime bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open bus recording: %w", err)
+	}
+	defer file.Close()
+
+	s.bus.SetReplaying(true)
+	defer s.bus.SetReplaying(false)
+
+	var pace <-chan time.Time // steps at the original pace
+	if realtime {
+		ticker := time.NewTicker(baseTickInterval)
+		defer ticker.Stop()
+		pace = ticker.C
+	}
+
+	records := bus.NewRecordReader(file)
+	loop := newLoopState()
+	count := 0
+	for {
+		rec, err := records.Next()
+		if err == io.EOF {
+			log.Printf("Replayed %d bus messages from %s", count, path)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for s.clock.Elapsed() < rec.Time {
+			if realtime {
+				select {
+				case <-s.stop:
+					return nil
+				case <-pace:
+				}
+			} else {
+				select {
+				case <-s.stop:
+					return nil
+				default:
+				}
+			}
+			s.step(loop)
+		}
+		if err := s.bus.Replay(rec); err != nil {
+			log.Printf("Error replaying %s on %s: %v", rec.Message.ID, rec.Topic, err)
+		}
+		count++
+	}
+}
@@ -0,0 +1,285 @@
+package ship
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"spacecraftsim/internal/device"
+)
+
+// redundancyGroup is a logical device backed by redundant units
+// N of its M units are active: commands to the group go to the active
+// units and their telemetry is republished under the group ID, e.g.
+// "<unit>.margin" as "<group>.margin". Standby units keep running.
+type redundancyGroup struct {
+	*device.BaseDevice
+	ship   *Ship
+	mu     sync.Mutex
+	units  []string // in failover order
+	active []string
+	auto   bool
+}
+
+// AddRedundancyGroup declares a group of redundant units
+// The first active units start active; with auto failover a failed or
+// switched off active unit is replaced by the first healthy standby
+func (s *Ship) AddRedundancyGroup(id string, units []string, active int, auto bool) error {
+	if active < 1 || active >= len(units) {
+		return fmt.Errorf("redundancy group %s: needs more units than the %d active", id, active)
+	}
+
+	s.mu.RLock()
+	ports := make([]*port, len(units))
+	for i, unit := range units {
+		p, exists := s.devices[unit]
+		if !exists {
+			s.mu.RUnlock()
+			return fmt.Errorf("redundancy group %s: unknown device %s", id, unit)
+		}
+		if p.group.Load() != nil {
+			s.mu.RUnlock()
+			return fmt.Errorf("redundancy group %s: %s already belongs to a group", id, unit)
+		}
+		ports[i] = p
+	}
+	s.mu.RUnlock()
+
+	g := &redundancyGroup{
+		BaseDevice: device.NewBaseDevice(id, 0),
+		ship:       s,
+		units:      append([]string(nil), units...),
+		active:     append([]string(nil), units[:active]...),
+		auto:       auto,
+	}
+	if err := s.RegisterDevice(g); err != nil {
+		return err
+	}
+	for _, p := range ports {
+		p.group.Store(g)
+	}
+
+	s.mu.Lock()
+	s.groups = append(s.groups, g)
+	s.mu.Unlock()
+	return nil
+}
+
+// isActive reports whether a unit is active
+func (g *redundancyGroup) isActive(unit string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.isActiveLocked(unit)
+}
+
+// logical returns the message ID of a unit message under the group ID
+func (g *redundancyGroup) logical(unit, id string) (string, bool) {
+	if id == unit {
+		return g.ID(), true
+	}
+	if strings.HasPrefix(id, unit+".") {
+		return g.ID() + strings.TrimPrefix(id, unit), true
+	}
+	return "", false
+}
+
+// HandleInput executes a group command
+func (g *redundancyGroup) HandleInput(msg device.Message) error {
+	_, err := g.Query(msg)
+	return err
+}
+
+// Query executes a group command and returns its result
+// Commands: ["status"], ["select", unit, ...] to choose the active units,
+// ["auto", on/off] for automatic failover. Anything else is forwarded to
+// the active units and the result of the first is returned.
+func (g *redundancyGroup) Query(msg device.Message) ([]interface{}, error) {
+	if len(msg.Values) > 0 {
+		switch strings.ToLower(fmt.Sprint(msg.Values[0])) {
+		case "status":
+			return []interface{}{g.status()}, nil
+		case "select":
+			var units []string
+			for _, v := range msg.Values[1:] {
+				units = append(units, fmt.Sprint(v))
+			}
+			if err := g.selectUnits(units, "commanded by "+msg.Source); err != nil {
+				return nil, err
+			}
+			return []interface{}{g.status()}, nil
+		case "auto":
+			if len(msg.Values) != 2 {
+				return nil, fmt.Errorf("redundancy group %s: auto needs on or off", g.ID())
+			}
+			on, err := device.ToBool(msg.Values[1])
+			if err != nil {
+				return nil, fmt.Errorf("redundancy group %s: %w", g.ID(), err)
+			}
+			g.mu.Lock()
+			g.auto = on
+			g.mu.Unlock()
+			return []interface{}{g.status()}, nil
+		}
+	}
+
+	g.mu.Lock()
+	active := append([]string(nil), g.active...)
+	g.mu.Unlock()
+
+	var result []interface{}
+	for i, unit := range active {
+		fwd := msg
+		fwd.ID = unit
+		// The group command was already accepted by the ship mode
+		values, err := g.ship.request(fwd)
+		if err != nil {
+			return nil, fmt.Errorf("redundancy group %s: %w", g.ID(), err)
+		}
+		if i == 0 {
+			result = values
+		}
+	}
+	return result, nil
+}
+
+// status describes the units of the group
+func (g *redundancyGroup) status() map[string]interface{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	units := make([]interface{}, len(g.units))
+	for i, unit := range g.units {
+		units[i] = map[string]interface{}{
+			"unit":   unit,
+			"health": g.ship.health.Get(unit).Status,
+		}
+	}
+	return map[string]interface{}{
+		"active": append([]string(nil), g.active...),
+		"units":  units,
+		"auto":   g.auto,
+	}
+}
+
+// selectUnits makes the given units active
+func (g *redundancyGroup) selectUnits(units []string, reason string) error {
+	g.mu.Lock()
+	if len(units) != len(g.active) {
+		g.mu.Unlock()
+		return fmt.Errorf("redundancy group %s: select %d units", g.ID(), len(g.active))
+	}
+	for _, unit := range units {
+		if !g.member(unit) {
+			g.mu.Unlock()
+			return fmt.Errorf("redundancy group %s: %s is not a unit of the group", g.ID(), unit)
+		}
+	}
+	from := strings.Join(g.active, ",")
+	g.active = append([]string(nil), units...)
+	g.mu.Unlock()
+
+	g.report(from, strings.Join(units, ","), reason)
+	return nil
+}
+
+// member reports whether a unit belongs to the group
+// The caller must hold g.mu
+func (g *redundancyGroup) member(unit string) bool {
+	for _, id := range g.units {
+		if id == unit {
+			return true
+		}
+	}
+	return false
+}
+
+// failover replaces failed or switched off active units by healthy standby
+// units when automatic failover is enabled
+func (g *redundancyGroup) failover() {
+	type swap struct{ from, to, reason string }
+	var swaps []swap
+
+	g.mu.Lock()
+	if !g.auto {
+		g.mu.Unlock()
+		return
+	}
+	for i, unit := range g.active {
+		h := g.ship.health.Get(unit)
+		if !unusable(h.Status) {
+			continue
+		}
+		reason := fmt.Sprintf("%s is %s", unit, h.Status)
+		if h.Reason != "" {
+			reason += ": " + h.Reason
+		}
+
+		var standby string
+		for _, candidate := range g.units {
+			if !g.isActiveLocked(candidate) && !unusable(g.ship.health.Get(candidate).Status) {
+				standby = candidate
+				break
+			}
+		}
+		if standby == "" {
+			continue // Nothing to switch to, the group reports itself failed
+		}
+		g.active[i] = standby
+		swaps = append(swaps, swap{unit, standby, reason})
+	}
+	g.mu.Unlock()
+
+	for _, s := range swaps {
+		g.report(s.from, s.to, s.reason)
+	}
+}
+
+// isActiveLocked reports whether a unit is active
+// The caller must hold g.mu
+func (g *redundancyGroup) isActiveLocked(unit string) bool {
+	for _, id := range g.active {
+		if id == unit {
+			return true
+		}
+	}
+	return false
+}
+
+// unusable reports whether a unit with the given status cannot be active
+func unusable(status device.HealthStatus) bool {
+	return status == device.HealthFailed || status == device.HealthOff
+}
+
+// report publishes a switch of active units on the "events" topic as
+// "<group>.failover" with the previous units, the new units and the reason
+func (g *redundancyGroup) report(from, to, reason string) {
+	log.Printf("Redundancy group %s switched from %s to %s: %s", g.ID(), from, to, reason)
+	g.ship.RecordEvent(g.ID()+".failover", from, to, reason)
+}
+
+// Health reports the group failed when an active unit is unusable and
+// degraded when no healthy standby unit remains
+func (g *redundancyGroup) Health() device.Health {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	spare := false
+	for _, unit := range g.units {
+		h := g.ship.health.Get(unit)
+		if g.isActiveLocked(unit) && unusable(h.Status) {
+			return device.Health{
+				Status: device.HealthFailed,
+				Code:   "no_unit",
+				Reason: fmt.Sprintf("active unit %s is %s", unit, h.Status),
+			}
+		}
+		if !g.isActiveLocked(unit) && !unusable(h.Status) {
+			spare = true
+		}
+	}
+	if !spare {
+		return device.Health{Status: device.HealthDegraded, Code: "no_spare", Reason: "no healthy standby unit"}
+	}
+	return device.Health{Status: device.HealthNominal}
+}
@@ -0,0 +1,89 @@
+package ship
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"spacecraftsim/internal/device"
+)
+
+// TimedCommand is a command stored for execution at a simulation time
+type TimedCommand struct {
+	ID        int
+	ExecuteAt time.Duration // simulation time
+	Message   device.Message
+}
+
+// Sequencer stores time-tagged commands until their execution time
+type Sequencer struct {
+	mu       sync.Mutex
+	nextID   int
+	commands []TimedCommand // sorted by execution time
+}
+
+// NewSequencer creates an empty sequencer
+func NewSequencer() *Sequencer {
+	return &Sequencer{nextID: 1}
+}
+
+// Add stores a command and returns it with its assigned ID
+func (q *Sequencer) Add(at time.Duration, msg device.Message) TimedCommand {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	cmd := TimedCommand{ID: q.nextID, ExecuteAt: at, Message: msg}
+	q.nextID++
+
+	// Keep commands with equal times in submission order
+	i := sort.Search(len(q.commands), func(i int) bool {
+		return q.commands[i].ExecuteAt > at
+	})
+	q.commands = append(q.commands, TimedCommand{})
+	copy(q.commands[i+1:], q.commands[i:])
+	q.commands[i] = cmd
+	return cmd
+}
+
+// List returns the stored commands in execution order
+func (q *Sequencer) List() []TimedCommand {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	list := make([]TimedCommand, len(q.commands))
+	copy(list, q.commands)
+	return list
+}
+
+// Delete removes a stored command
+func (q *Sequencer) Delete(id int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, cmd := range q.commands {
+		if cmd.ID == id {
+			q.commands = append(q.commands[:i], q.commands[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown timed command: %d", id)
+}
+
+// due removes and returns the commands whose execution time has arrived
+func (q *Sequencer) due(now time.Duration) []TimedCommand {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := 0
+	for n < len(q.commands) && q.commands[n].ExecuteAt <= now {
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	due := make([]TimedCommand, n)
+	copy(due, q.commands[:n])
+	q.commands = q.commands[n:]
+	return due
+}
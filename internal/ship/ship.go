@@ -3,6 +3,7 @@ package ship
 import (
 	"fmt"
 	"log"
+	"os"
 	"sync"
 	"time"
 
@@ -10,40 +11,151 @@ import (
 	"spacecraftsim/internal/device"
 )
 
+// baseTickInterval is the ship loop period and the simulation time step
+const baseTickInterval = 10 * time.Millisecond
+
 // Ship represents the spacecraft system
 type Ship struct {
-	devices map[string]device.Device
-	bus     *bus.MessageBus
-	mu      sync.RWMutex
-	stop    chan struct{}
+	devices   map[string]*port
+	bus       *bus.MessageBus
+	clock     *Clock
+	sequencer *Sequencer
+	health    *HealthMonitor
+	faults    *FaultInjector
+	deps      map[string][]Dependency // by downstream device
+	groups    []*redundancyGroup
+	events    *EventLog
+	metrics   *Metrics
+	modes     *modeManager          // nil without ship modes
+	buses     map[string]device.Bus // buses replacing the ship bus by device
+	recording *os.File              // bus recording, nil unless recording
+	onResult  func(cmd TimedCommand, err error)
+	mu        sync.RWMutex
+	stop      chan struct{}
 }
 
 // New creates a new ship system
 func New() *Ship {
-	return &Ship{
-		devices: make(map[string]device.Device),
-		bus:     bus.NewMessageBus(),
-		stop:    make(chan struct{}),
+	clock := NewClock()
+	s := &Ship{
+		devices:   make(map[string]*port),
+		bus:       bus.NewMessageBus(),
+		clock:     clock,
+		sequencer: NewSequencer(),
+		health:    NewHealthMonitor(),
+		faults:    NewFaultInjector(clock),
+		deps:      make(map[string][]Dependency),
+		buses:     make(map[string]device.Bus),
+		events:    NewEventLog(0),
+		metrics:   NewMetrics(),
+		stop:      make(chan struct{}),
+	}
+
+	// Count message handling errors towards device health
+	s.bus.SetDeliveryHandler(func(dev device.Device, err error) {
+		s.health.RecordInput(dev.ID(), err)
+	})
+
+	// Let devices command each other through the bus
+	router := &commandRouter{BaseDevice: device.NewBaseDevice("command_router", 0), ship: s}
+	router.AddTopic("commands")
+	if err := router.Subscribe(s.bus); err != nil {
+		log.Printf("Error subscribing command router: %v", err)
 	}
+
+	// Let clients, timed commands and devices inject faults
+	commander := &faultCommander{BaseDevice: device.NewBaseDevice("faults", 0), ship: s}
+	if err := s.RegisterDevice(commander); err != nil {
+		log.Printf("Error registering fault commander: %v", err)
+	}
+
+	return s
+}
+
+// commandRouter delivers messages published on the "commands" topic to the
+// device addressed by the message ID
+type commandRouter struct {
+	*device.BaseDevice
+	ship *Ship
+}
+
+// Subscribe registers the router to receive commands
+func (r *commandRouter) Subscribe(bus device.Bus) error {
+	return r.SubscribeAs(bus, r)
+}
+
+// HandleInput routes a command to its device
+func (r *commandRouter) HandleInput(msg device.Message) error {
+	return r.ship.HandleMessage(msg)
 }
 
 // RegisterDevice adds a device to the ship
 func (s *Ship) RegisterDevice(dev device.Device) error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	if _, exists := s.devices[dev.ID()]; exists {
+		s.mu.Unlock()
 		return fmt.Errorf("device with ID %s already exists", dev.ID())
 	}
 
-	if err := dev.Subscribe(s.bus); err != nil {
+	// Give devices simulation time
+	if clocked, ok := dev.(interface{ SetClock(device.Clock) }); ok {
+		clocked.SetClock(s.clock)
+	}
+
+	// Connect the device to the bus through a port so faults can be
+	// injected into its messages
+	var b device.Bus = s.bus
+	if attached, exists := s.buses[dev.ID()]; exists {
+		b = attached
+	}
+	p := &port{dev: dev, bus: b, faults: s.faults, clock: s.clock, metrics: s.metrics}
+	s.devices[dev.ID()] = p
+	s.mu.Unlock()
+
+	// Subscribe without the lock, retained messages are delivered at once
+	// and the device may command other devices in response
+	if err := p.Subscribe(s.bus); err != nil {
+		s.mu.Lock()
+		delete(s.devices, dev.ID())
+		s.mu.Unlock()
 		return fmt.Errorf("failed to subscribe device %s: %w", dev.ID(), err)
 	}
+	return nil
+}
+
+// Bus returns the ship bus
+func (s *Ship) Bus() device.Bus {
+	return s.bus
+}
 
-	s.devices[dev.ID()] = dev
+// SetDeviceBus connects a device to another bus than the ship bus, e.g. a
+// remote terminal of an avionics bus or a CAN node bridged to the ship bus
+// It must be called before the device is registered
+func (s *Ship) SetDeviceBus(id string, b device.Bus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.devices[id]; exists {
+		return fmt.Errorf("device %s is already registered", id)
+	}
+	if _, attached := s.buses[id]; attached {
+		return fmt.Errorf("device %s is already attached to another bus", id)
+	}
+	s.buses[id] = b
 	return nil
 }
 
+// RetainTopics keeps the last message of every ID published on topics
+// matching the given filters for new subscribers and Retained
+func (s *Ship) RetainTopics(filters ...string) error {
+	return s.bus.Retain(filters...)
+}
+
+// Retained returns the last messages published on retained topics
+// matching a filter
+func (s *Ship) Retained(filter string) ([]bus.Retained, error) {
+	return s.bus.Retained(filter)
+}
+
 // Start begins the ship's operation
 func (s *Ship) Start() {
 	go s.run()
@@ -52,6 +164,63 @@ func (s *Ship) Start() {
 // Stop halts the ship's operation
 func (s *Ship) Stop() {
 	close(s.stop)
+	s.bus.Close()
+	if s.BusRecording() != "" {
+		if err := s.StopBusRecording(); err != nil {
+			log.Printf("Error closing bus recording: %v", err)
+		}
+	}
+}
+
+// EnableAsyncBus delivers bus messages through a queue per subscriber
+// holding size messages, with overflow deciding what happens when a queue
+// is full
+func (s *Ship) EnableAsyncBus(size int, overflow bus.OverflowPolicy) error {
+	return s.bus.EnableAsync(size, overflow)
+}
+
+// AddBusInterceptor adds an interceptor to the bus chain and returns its
+// ID for RemoveBusInterceptor
+func (s *Ship) AddBusInterceptor(ic bus.Interceptor) (int, error) {
+	return s.bus.AddInterceptor(ic)
+}
+
+// RemoveBusInterceptor removes an interceptor from the bus chain
+func (s *Ship) RemoveBusInterceptor(id int) error {
+	return s.bus.RemoveInterceptor(id)
+}
+
+// BusInterceptors returns the bus interceptors in chain order
+func (s *Ship) BusInterceptors() []bus.Interceptor {
+	return s.bus.Interceptors()
+}
+
+// BusQueues returns the state of the bus subscriber queues, nil for
+// synchronous delivery
+func (s *Ship) BusQueues() []bus.QueueStats {
+	return s.bus.QueueStats()
+}
+
+// DeviceMetrics returns the runtime metrics of the devices ordered by
+// device
+func (s *Ship) DeviceMetrics() []DeviceMetrics {
+	return s.metrics.Devices()
+}
+
+// LoopMetrics returns the runtime metrics of the ship loop
+func (s *Ship) LoopMetrics() LoopMetrics {
+	return s.metrics.Loop()
+}
+
+// BusTopics returns the traffic of every topic published on the ship bus
+func (s *Ship) BusTopics() []bus.TopicStats {
+	return s.bus.TopicStats()
+}
+
+// BusSubscriptions returns the number of subscribers of every topic filter
+// of the ship bus
+func (s *Ship) BusSubscriptions() map[string]int {
+	return s.bus.Subscriptions()
 }
 
 // HandleMessage processes an incoming message
@@ -63,41 +232,409 @@ func (s *Ship) HandleMessage(msg device.Message) error {
 	if !exists {
 		return fmt.Errorf("unknown device: %s", msg.ID)
 	}
+	if dev.silenced.Load() {
+		return s.silencedError(msg.ID)
+	}
+	if err := s.checkMode(msg); err != nil {
+		return err
+	}
+
+	err := dev.HandleInput(msg)
+	s.recordCommand(msg.ID, err)
+	return err
+}
+
+// recordCommand counts a command handled by a device towards its health
+// Errors are those of the command, malformed values or unknown commands
+// from clients and sequences, rather than of the device, and do not
+// degrade it.
+func (s *Ship) recordCommand(id string, err error) {
+	if err == nil {
+		s.health.RecordInput(id, nil)
+	}
+}
+
+// Request processes an incoming message and returns the values to report
+// back to the sender. Queryable devices compute the result, other devices
+// handle the message as input and the message values are returned.
+func (s *Ship) Request(msg device.Message) ([]interface{}, error) {
+	if err := s.checkMode(msg); err != nil {
+		return nil, err
+	}
+	return s.request(msg)
+}
+
+// request processes a message accepted by the ship mode
+func (s *Ship) request(msg device.Message) ([]interface{}, error) {
+	s.mu.RLock()
+	dev, exists := s.devices[msg.ID]
+	s.mu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("unknown device: %s", msg.ID)
+	}
+	if dev.silenced.Load() {
+		return nil, s.silencedError(msg.ID)
+	}
+
+	if q, ok := dev.dev.(device.Queryable); ok {
+		if s.faults.failed(msg.ID) {
+			return nil, fmt.Errorf("device %s is not responding", msg.ID)
+		}
+		values, err := q.Query(msg)
+		s.recordCommand(msg.ID, err)
+		return values, err
+	}
+	err := dev.HandleInput(msg)
+	s.recordCommand(msg.ID, err)
+	if err != nil {
+		return nil, err
+	}
+	return msg.Values, nil
+}
+
+// Call sends a request to a device on the "commands" topic and returns
+// the values of its reply
+// Unlike Request the result is computed by the device behind its port,
+// so input and output faults apply and a device that does not answer
+// within the timeout fails the call with a device.NoReplyError
+func (s *Ship) Call(msg device.Message, timeout time.Duration) ([]interface{}, error) {
+	if timeout <= 0 {
+		return nil, fmt.Errorf("timeout must be positive")
+	}
+
+	s.mu.RLock()
+	dev, exists := s.devices[msg.ID]
+	s.mu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("unknown device: %s", msg.ID)
+	}
+	if dev.silenced.Load() {
+		return nil, s.silencedError(msg.ID)
+	}
+	if err := s.checkMode(msg); err != nil {
+		return nil, err
+	}
+
+	reply, err := s.bus.Request("commands", msg, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return reply.Values, nil
+}
+
+// silencedError explains why a silenced device cannot be reached
+func (s *Ship) silencedError(id string) error {
+	h := s.health.Get(id)
+	return fmt.Errorf("device %s is %s: %s", id, h.Status, h.Reason)
+}
+
+// AddDependency makes a device depend on an upstream device
+// When the upstream device fails or is switched off the effect applies to
+// the device and, through their own dependencies, to devices further down
+func (s *Ship) AddDependency(dep Dependency) error {
+	switch dep.Effect {
+	case EffectMute, EffectFail, EffectDegrade:
+	default:
+		return fmt.Errorf("unknown dependency effect %s", dep.Effect)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range []string{dep.Device, dep.Upstream} {
+		if _, exists := s.devices[id]; !exists {
+			return fmt.Errorf("unknown device: %s", id)
+		}
+	}
+	if dep.Device == dep.Upstream {
+		return fmt.Errorf("device %s cannot depend on itself", dep.Device)
+	}
+	s.deps[dep.Device] = append(s.deps[dep.Device], dep)
+	return nil
+}
+
+// Dependencies returns the dependencies of a device
+func (s *Ship) Dependencies(id string) []Dependency {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Dependency(nil), s.deps[id]...)
+}
+
+// SimTime returns the current simulation time
+func (s *Ship) SimTime() time.Duration {
+	return s.clock.Elapsed()
+}
+
+// Schedule stores a command for execution at simulation time at
+func (s *Ship) Schedule(msg device.Message, at time.Duration) (TimedCommand, error) {
+	if now := s.clock.Elapsed(); at < now {
+		return TimedCommand{}, fmt.Errorf("execution time %v is before current simulation time %v", at, now)
+	}
+	return s.sequencer.Add(at, msg), nil
+}
+
+// Scheduled returns the stored time-tagged commands in execution order
+func (s *Ship) Scheduled() []TimedCommand {
+	return s.sequencer.List()
+}
+
+// Unschedule deletes a stored time-tagged command
+func (s *Ship) Unschedule(id int) error {
+	return s.sequencer.Delete(id)
+}
+
+// Health returns the health of a device
+func (s *Ship) Health(id string) (device.Health, error) {
+	s.mu.RLock()
+	_, exists := s.devices[id]
+	s.mu.RUnlock()
+
+	if !exists {
+		return device.Health{}, fmt.Errorf("unknown device: %s", id)
+	}
+	return s.health.Get(id), nil
+}
+
+// HealthReport returns the health of every device and the worst of them
+func (s *Ship) HealthReport() (map[string]device.Health, device.HealthStatus) {
+	return s.health.All(), s.health.Overall()
+}
+
+// ResetHealth clears the error counts of a device
+func (s *Ship) ResetHealth(id string) error {
+	s.mu.RLock()
+	_, exists := s.devices[id]
+	s.mu.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("unknown device: %s", id)
+	}
+	s.health.Reset(id)
+	return nil
+}
+
+// SetHealthThresholds sets the default error thresholds of device health
+func (s *Ship) SetHealthThresholds(t HealthThresholds) {
+	s.health.SetThresholds(t)
+}
+
+// SetDeviceHealthThresholds sets the error thresholds of a single device
+func (s *Ship) SetDeviceHealthThresholds(id string, t HealthThresholds) {
+	s.health.SetDeviceThresholds(id, t)
+}
+
+// updateHealth re-evaluates device health, propagates failures to
+// dependent devices and publishes the changes on the "health" topic as
+// "<device>.health" and the aggregate as "ship.health"
+func (s *Ship) updateHealth(devices []*port, overall *device.HealthStatus) {
+	now := s.clock.Now()
+
+	s.mu.RLock()
+	modes := s.modes
+	s.mu.RUnlock()
+
+	own := make(map[string]device.Health, len(devices))
+	for _, dev := range devices {
+		var reported device.Health
+		if r, ok := dev.dev.(device.HealthReporter); ok {
+			reported = r.Health()
+		}
+		if modes != nil {
+			if h, disabled := modes.disabled(dev.ID()); disabled {
+				reported = h
+			}
+		}
+		own[dev.ID()] = s.health.Evaluate(dev.ID(), reported)
+	}
+	s.mu.RLock()
+	health := propagate(own, s.deps)
+	s.mu.RUnlock()
+
+	for _, dev := range devices {
+		h := health[dev.ID()]
+		dev.silenced.Store(silenced(h))
+		if h, changed := s.health.Update(dev.ID(), h, now); changed {
+			if h.Status != device.HealthNominal {
+				log.Printf("Device %s is %s: %s %s", dev.ID(), h.Status, h.Code, h.Reason)
+			}
+			s.publishHealth(dev.ID(), h)
+		}
+	}
+
+	s.mu.RLock()
+	groups := append([]*redundancyGroup(nil), s.groups...)
+	s.mu.RUnlock()
+	for _, g := range groups {
+		g.failover()
+	}
+
+	if status := s.health.Overall(); status != *overall {
+		*overall = status
+		s.publishHealth("ship", device.Health{Status: status})
+	}
+}
+
+// publishHealth sends a health change on the "health" topic
+// Values are status, fault code, reason and root cause device
+func (s *Ship) publishHealth(id string, h device.Health) {
+	msg := device.Message{
+		ID:     id + ".health",
+		Values: []interface{}{string(h.Status), h.Code, h.Reason, h.Cause},
+		Time:   s.clock.Now(),
+		Source: "ship",
+	}
+	if err := s.bus.Publish("health", msg); err != nil {
+		log.Printf("Error publishing health of %s: %v", id, err)
+	}
+}
+
+// InjectFault injects a fault into a device
+func (s *Ship) InjectFault(f Fault) (Fault, error) {
+	s.mu.RLock()
+	_, exists := s.devices[f.Device]
+	s.mu.RUnlock()
+
+	if !exists {
+		return Fault{}, fmt.Errorf("unknown device: %s", f.Device)
+	}
+	if f.Device == "faults" {
+		return Fault{}, fmt.Errorf("cannot inject faults into the fault commander")
+	}
+	return s.faults.Inject(f)
+}
+
+// ClearFault removes an injected fault
+func (s *Ship) ClearFault(id int) error {
+	return s.faults.Clear(id)
+}
+
+// ClearFaults removes the faults of a device, or of every device for an
+// empty ID, and returns how many were removed
+func (s *Ship) ClearFaults(dev string) int {
+	return s.faults.ClearDevice(dev)
+}
+
+// Faults returns the active faults
+func (s *Ship) Faults() []Fault {
+	return s.faults.List()
+}
+
+// RecordEvent records an event in the event log and reports it on the
+// "events" topic
+// Event IDs are "<origin>.<event>", e.g. "cabin_pressure.failover"
+func (s *Ship) RecordEvent(id string, values ...interface{}) {
+	s.events.add(Event{Time: s.clock.Elapsed(), ID: id, Values: values})
+
+	msg := device.Message{
+		ID:     id,
+		Values: values,
+		Time:   s.clock.Now(),
+		Source: "ship",
+	}
+	if err := s.bus.Publish("events", msg); err != nil {
+		log.Printf("Error publishing event %s: %v", id, err)
+	}
+}
+
+// Events returns the last n events, or all kept events for n <= 0
+func (s *Ship) Events(n int) []Event {
+	return s.events.Last(n)
+}
+
+// PersistEvents appends every following event to a file as JSON lines
+func (s *Ship) PersistEvents(path string) error {
+	return s.events.Persist(path)
+}
 
-	return dev.HandleInput(msg)
+// SetResultHandler sets the handler notified when a timed command executes
+func (s *Ship) SetResultHandler(handler func(cmd TimedCommand, err error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onResult = handler
+}
+
+// executeDue dispatches the timed commands whose execution time has arrived
+func (s *Ship) executeDue(now time.Duration) {
+	for _, cmd := range s.sequencer.due(now) {
+		err := s.HandleMessage(cmd.Message)
+		if err != nil {
+			log.Printf("Error executing timed command %d: %v", cmd.ID, err)
+		}
+
+		s.mu.RLock()
+		onResult := s.onResult
+		s.mu.RUnlock()
+		if onResult != nil {
+			onResult(cmd, err)
+		}
+	}
 }
 
 // run executes the main ship loop
 func (s *Ship) run() {
 	// Use a faster base ticker for more precise timing
-	baseTicker := time.NewTicker(10 * time.Millisecond)
+	baseTicker := time.NewTicker(baseTickInterval)
 	defer baseTicker.Stop()
 
-	// Track last tick time for each device
-	lastTicks := make(map[string]time.Time)
-
+	loop := newLoopState()
 	for {
 		select {
 		case <-s.stop:
 			return
 		case <-baseTicker.C:
-			s.mu.RLock()
-			now := time.Now()
-			for _, dev := range s.devices {
-				tickRate := dev.GetTickRate()
-				// Skip devices with zero tick rate
-				if tickRate == 0 {
-					continue
-				}
-				lastTick := lastTicks[dev.ID()]
-				if now.Sub(lastTick) >= tickRate {
-					if err := dev.Tick(); err != nil {
-						log.Printf("Error ticking device %s: %v", dev.ID(), err)
-					}
-					lastTicks[dev.ID()] = now
-				}
+			s.step(loop)
+		}
+	}
+}
+
+// loopState is what the ship loop keeps between steps
+type loopState struct {
+	lastTicks map[string]time.Duration // simulation time of the last tick by device
+	overall   device.HealthStatus
+}
+
+// newLoopState returns the state of a loop that has not stepped yet
+func newLoopState() *loopState {
+	return &loopState{lastTicks: make(map[string]time.Duration)}
+}
+
+// step advances simulation time by one base tick, executes the due timed
+// commands and ticks the devices that are due
+func (s *Ship) step(loop *loopState) {
+	start := time.Now()
+	defer func() { s.metrics.RecordStep(time.Since(start)) }()
+
+	now := s.clock.advance(baseTickInterval)
+	s.executeDue(now)
+	s.faults.release(now)
+
+	// Tick outside the lock so devices can command each other
+	// through HandleMessage
+	s.mu.RLock()
+	devices := make([]*port, 0, len(s.devices))
+	for _, dev := range s.devices {
+		devices = append(devices, dev)
+	}
+	s.mu.RUnlock()
+
+	for _, dev := range devices {
+		tickRate := dev.GetTickRate()
+		// Skip devices with zero tick rate
+		if tickRate == 0 {
+			continue
+		}
+		lastTick, ticked := loop.lastTicks[dev.ID()]
+		if !ticked || now-lastTick >= tickRate {
+			err := dev.Tick()
+			if err != nil {
+				log.Printf("Error ticking device %s: %v", dev.ID(), err)
 			}
-			s.mu.RUnlock()
+			s.health.RecordTick(dev.ID(), err)
+			loop.lastTicks[dev.ID()] = now
 		}
 	}
+
+	s.updateHealth(devices, &loop.overall)
 }